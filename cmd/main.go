@@ -4,6 +4,7 @@ import (
 	"RemainsManager/config"
 	"RemainsManager/internal/handlers"
 	"RemainsManager/internal/middleware"
+	"RemainsManager/internal/models"
 	"RemainsManager/internal/repositories"
 	"RemainsManager/internal/services"
 	"context"
@@ -74,6 +75,9 @@ func main() {
 
 	// Инициализация репозиториев
 	authRepo := repositories.NewAuthRepository(cfg.Database.Timeout, db)
+	accessRepo := repositories.NewAccessRepository(cfg.Database.Timeout, db)
+	apiKeyRepo := repositories.NewAPIKeyRepository(cfg.Database.Timeout, db)
+	auditRepo := repositories.NewAuditRepository(cfg.Database.Timeout, db)
 	userRepo := repositories.NewUserRepository(cfg.Database.Timeout, db)
 	pharmacyRepo := repositories.NewPharmacyRepository(cfg.Database.Timeout, db)
 	productsRepo := repositories.NewProductRepository(cfg.Database.Timeout, db)
@@ -81,67 +85,127 @@ func main() {
 	offerRepo := repositories.NewOfferRepository(cfg.Database.Timeout, db)
 	reportsRepo := repositories.NewReportRepository(db)
 
+	var tokenDenylist repositories.TokenDenylist
+	if cfg.Security.TokenDenylist == "memory" {
+		tokenDenylist = repositories.NewMemoryTokenDenylist()
+	} else {
+		tokenDenylist = repositories.NewSQLTokenDenylist(cfg.Database.Timeout, db)
+	}
+
+	var authenticators []services.Authenticator
+	for _, name := range cfg.Security.Authenticators {
+		switch name {
+		case "erp":
+			authenticators = append(authenticators, services.NewERPAuthenticator(authRepo))
+		case "ldap":
+			authenticators = append(authenticators, services.NewLDAPAuthenticator(cfg.Security.LDAP, userRepo))
+		}
+	}
+
 	// Инициализация сервисов
-	authService := services.NewAuthService(authRepo, cfg.Security.JWTSecret)
-	userService := services.NewUserService(userRepo)
+	authService := services.NewAuthService(authenticators, accessRepo, tokenDenylist, auditRepo, cfg.Security)
+	accessService := services.NewAccessService(accessRepo)
+	apiKeyService := services.NewAPIKeyService(apiKeyRepo)
+	auditService := services.NewAuditService(auditRepo)
 	pharmacyService := services.NewPharmacyService(pharmacyRepo)
+	userService := services.NewUserService(userRepo, accessService, pharmacyService)
 	productService := services.NewProductService(productsRepo)
 	routeService := services.NewRouteService(routsRepo)
 	offerService := services.NewOfferService(offerRepo)
+	offerJobService := services.NewOfferJobService(offerService)
 	autoDistributeService := services.NewAutoDistributeService(productService, offerRepo)
 	reportService := services.NewReportService(reportsRepo)
 
 	// Инициализация хендлеров
 	authHandler := handlers.NewAuthHandler(authService)
-	userHandler := handlers.NewUserHandler(userService)
-	pharmacyHandler := handlers.NewPharmacyHandler(pharmacyService)
-	productHandler := handlers.NewProductHandler(productService)
+	userHandler := handlers.NewUserHandler(userService, accessService)
+	pharmacyHandler := handlers.NewPharmacyHandler(pharmacyService, accessService)
+	productHandler := handlers.NewProductHandler(productService, accessService)
 	routeHandler := handlers.NewRouteHandler(routeService)
-	offerHandler := handlers.NewOfferHandler(offerService, autoDistributeService)
-	reportHandler := handlers.NewReportHandler(reportService)
+	offerHandler := handlers.NewOfferHandler(offerService, autoDistributeService, accessService, offerJobService)
+	reportHandler := handlers.NewReportHandler(reportService, offerService, accessService)
+	adminHandler := handlers.NewAdminHandler(accessService, apiKeyService, auditService)
 
 	// Роутер
 	r := chi.NewRouter()
 	r.Use(middleware.EnableCORS)
 	r.Post("/login", authHandler.Login)
-	r.Get("/users", userHandler.GetAllUsers)
+	r.Post("/refresh", authHandler.Refresh)
 
 	// Защищённые маршруты
 	r.Route("/api", func(r chi.Router) {
-		r.Use(middleware.AuthMiddleware)
-		r.Get("/pharmacies", pharmacyHandler.GetPharmacies)
-		r.Get("/inactive-products", productHandler.GetInactiveStockProducts)
-		r.Get("/products-with-sales-speed", productHandler.GetProductStockWithSalesSpeed)
-		r.Get("/inactive-products/export", productHandler.ExportInactiveStockProductsExcel)
+		r.Use(middleware.NewAuthMiddleware(cfg.Security, tokenDenylist, apiKeyService))
+		r.Use(middleware.NewAuditMiddleware(auditRepo))
+		can := middleware.RequirePermission
+
+		r.Post("/logout", authHandler.Logout)
+		r.Get("/me", userHandler.GetMe)
+		r.Put("/me/preferences", userHandler.UpdatePreferences)
+
+		r.With(can(models.PermissionRead)).Get("/pharmacies", pharmacyHandler.GetPharmacies)
+		r.With(can(models.PermissionRead)).Get("/inactive-products", productHandler.GetInactiveStockProducts)
+		r.With(can(models.PermissionRead)).Get("/products-with-sales-speed", productHandler.GetProductStockWithSalesSpeed)
+		r.With(can(models.PermissionRead)).Get("/inactive-products/export", productHandler.ExportInactiveStockProductsExcel)
 
 		// Маршруты
-		r.Post("/routes", routeHandler.CreateRoute)
-		r.Get("/routes", routeHandler.GetRoutes)
-		r.Delete("/routes/{id}", routeHandler.DeleteRoute)
+		r.With(can(models.PermissionRoutesEdit)).Post("/routes", routeHandler.CreateRoute)
+		r.With(can(models.PermissionRead)).Get("/routes", routeHandler.GetRoutes)
+		r.With(can(models.PermissionRoutesEdit)).Delete("/routes/{id}", routeHandler.DeleteRoute)
 
 		// Пункты маршрута
-		r.Post("/route-items", routeHandler.AddRouteItem)
-		r.Get("/route-items", routeHandler.GetRouteItems)
+		r.With(can(models.PermissionRoutesEdit)).Post("/route-items", routeHandler.AddRouteItem)
+		r.With(can(models.PermissionRead)).Get("/route-items", routeHandler.GetRouteItems)
 		//r.Get("/route-items/{id}", routeHandler.GetRouteItems)
-		r.Delete("/route-items/{id}", routeHandler.DeleteRouteItem)
-		r.Put("/routes/{id}/items", routeHandler.UpdateRouteItems)
+		r.With(can(models.PermissionRoutesEdit)).Delete("/route-items/{id}", routeHandler.DeleteRouteItem)
+		r.With(can(models.PermissionRoutesEdit)).Put("/routes/{id}/items", routeHandler.UpdateRouteItems)
 
 		//Заявки
-		r.Get("/offer", offerHandler.GetOrCreateOffer)
-		r.Post("/offer-items", offerHandler.AddOfferItems)
+		// GET /offer создаёт заявку, поэтому попадает в аудит, хотя GET общий аудит пропускает
+		r.With(can(models.PermissionOffersEdit), middleware.NewRouteAuditMiddleware(auditRepo)).Get("/offer", offerHandler.GetOrCreateOffer)
+		r.With(can(models.PermissionOffersEdit)).Post("/offer-items", offerHandler.AddOfferItems)
 
 		// Журнал и детали
-		r.Get("/offers/journal", offerHandler.GetOfferJournal)
-		r.Get("/offers/{id}/details", offerHandler.GetOfferDetails)
-		r.Put("/offer-items/{id}", offerHandler.UpdateOfferItem)
-		r.Delete("/offer-items/{id}", offerHandler.DeleteOfferItem)
-		r.Put("/offers/{id}/send", offerHandler.MarkOfferAsSent)
-		r.Delete("/offers/{id}", offerHandler.DeleteOffer)
-		r.Post("/offers/{id}/process", offerHandler.ProcessOffer)
-		r.Post("/offers/auto-distribute", offerHandler.AutoDistribute)
+		r.With(can(models.PermissionOffersEdit)).Post("/offers", offerHandler.CreateDraft)
+		r.With(can(models.PermissionRead)).Get("/offers/drafts", offerHandler.GetOpenDrafts)
+		r.With(can(models.PermissionRead)).Get("/offers/journal", offerHandler.GetOfferJournal)
+		r.With(can(models.PermissionRead)).Get("/offers/{id}/details", offerHandler.GetOfferDetails)
+		r.With(can(models.PermissionRead)).Get("/offers/{id}/history", offerHandler.GetOfferHistory)
+		r.With(can(models.PermissionRead)).Get("/offers/{id}/movings", offerHandler.GetOfferMovings)
+		r.With(can(models.PermissionRead)).Get("/offers/{id}/validate", offerHandler.ValidateOffer)
+		r.With(can(models.PermissionRead)).Get("/offers/{id}/documents", offerHandler.GetOfferDocuments)
+		r.With(can(models.PermissionRead)).Get("/offers/{id}/documents/archive", offerHandler.DownloadOfferDocumentsArchive)
+		r.With(can(models.PermissionRead)).Get("/offers/{id}/documents/{documentId}", offerHandler.DownloadOfferDocument)
+		r.With(can(models.PermissionOffersEdit)).Put("/offer-items/{id}", offerHandler.UpdateOfferItem)
+		r.With(can(models.PermissionOffersEdit)).Delete("/offer-items/{id}", offerHandler.DeleteOfferItem)
+		r.With(can(models.PermissionOffersEdit)).Patch("/offers/{id}/items", offerHandler.PatchOfferItems)
+		r.With(can(models.PermissionOffersEdit)).Post("/offers/{id}/items/move", offerHandler.MoveOfferItems)
+		r.With(can(models.PermissionOffersEdit)).Post("/offers/{id}/items/scale", offerHandler.ScaleOfferItems)
+		r.With(can(models.PermissionOffersEdit)).Delete("/offers/{id}/receivers/{receiverId}/items", offerHandler.DeleteReceiverItems)
+		r.With(can(models.PermissionOffersSend)).Put("/offers/{id}/send", offerHandler.MarkOfferAsSent)
+		r.With(can(models.PermissionOffersDelete)).Delete("/offers/{id}", offerHandler.DeleteOffer)
+		r.With(can(models.PermissionOffersProcess)).Get("/offers/{id}/preview", offerHandler.PreviewOffer)
+		r.With(can(models.PermissionOffersProcess)).Post("/offers/{id}/process", offerHandler.ProcessOffer)
+		r.With(can(models.PermissionOffersProcess)).Post("/offers/{id}/retry", offerHandler.RetryOffer)
+		r.With(can(models.PermissionRead)).Get("/offers/{id}/receivers", offerHandler.GetReceiverOutcomes)
+		r.With(can(models.PermissionRead)).Get("/jobs/{jobId}", offerHandler.GetJob)
+		r.With(can(models.PermissionAutoDistribute)).Post("/offers/auto-distribute", offerHandler.AutoDistribute)
 
 		//Отчет
-		r.Get("/report/offer", reportHandler.GenerateOfferReport)
+		r.With(can(models.PermissionRead)).Get("/report/offer", reportHandler.GenerateOfferReport)
+
+		// Администрирование
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(can(models.PermissionAdmin))
+			r.Get("/users", userHandler.GetAllUsers)
+			r.Get("/users/{name}/roles", adminHandler.GetUserRoles)
+			r.Put("/users/{name}/roles", adminHandler.SetUserRoles)
+			r.Get("/users/{name}/pharmacies", adminHandler.GetUserPharmacies)
+			r.Put("/users/{name}/pharmacies", adminHandler.SetUserPharmacies)
+			r.Post("/api-keys", adminHandler.CreateAPIKey)
+			r.Get("/api-keys", adminHandler.GetAPIKeys)
+			r.Delete("/api-keys/{id}", adminHandler.RevokeAPIKey)
+			r.Get("/audit", adminHandler.GetAuditLog)
+		})
 
 	})
 	// Swagger
@@ -187,6 +251,15 @@ func main() {
 		log.Println("Server stopped gracefully")
 	}
 
+	// Дожидаемся фоновых заданий обработки заявок: они пишут в БД
+	jobsCtx, cancelJobs := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancelJobs()
+	if err := offerJobService.Shutdown(jobsCtx); err != nil {
+		log.Printf("Offer jobs forced shutdown: %v", err)
+	} else {
+		log.Println("Offer jobs finished")
+	}
+
 	// Закрываем соединение с БД
 	if err := db.Close(); err != nil {
 		log.Printf("Error closing database: %v", err)
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
 
@@ -26,7 +27,71 @@ type DatabaseConfig struct {
 }
 
 type SecurityConfig struct {
-	JWTSecret string `yaml:"jwt_secret"`
+	JWTSecret       string   `yaml:"jwt_secret"`        // ключ токенов без kid
+	JWTKeys         []JWTKey `yaml:"jwt_keys"`          // активные ключи для ротации
+	JWTActiveKey    string   `yaml:"jwt_active_key"`    // kid ключа для подписи новых токенов
+	AccessTokenTTL  int      `yaml:"access_token_ttl"`  // минуты
+	RefreshTokenTTL int      `yaml:"refresh_token_ttl"` // часы
+	TokenDenylist   string   `yaml:"token_denylist"`    // sql | memory
+
+	// Защита входа от подбора пароля
+	LoginMaxAttempts      int `yaml:"login_max_attempts"`        // неудачных попыток на логин до блокировки
+	LoginMaxAttemptsPerIP int `yaml:"login_max_attempts_per_ip"` // неудачных попыток с одного IP до блокировки
+	LoginBackoffBase      int `yaml:"login_backoff_base"`        // начальная задержка после неудачи, секунды
+	LoginLockout          int `yaml:"login_lockout"`             // длительность блокировки, минуты
+
+	// Способы входа: erp | ldap, проверяются в указанном порядке
+	Authenticators []string   `yaml:"authenticators"`
+	LDAP           LDAPConfig `yaml:"ldap"`
+}
+
+// LDAPConfig — вход по доменной учётной записи (LDAP / Active Directory).
+// Пользователь ищется под служебной учётной записью, затем пароль проверяется bind'ом.
+type LDAPConfig struct {
+	URL                string `yaml:"url"`                  // ldap://host:389 или ldaps://host:636
+	StartTLS           bool   `yaml:"start_tls"`            // перейти на TLS после подключения по ldap://
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // не проверять сертификат сервера
+	BindDN             string `yaml:"bind_dn"`              // служебная учётная запись для поиска; пусто — анонимно
+	BindPassword       string `yaml:"bind_password"`
+	BaseDN             string `yaml:"base_dn"`
+	UserFilter         string `yaml:"user_filter"`         // %s заменяется экранированным логином
+	UsernameAttribute  string `yaml:"username_attribute"`  // атрибут, совпадающий с meta_user.NAME
+	FullNameAttribute  string `yaml:"full_name_attribute"` // ФИО, если пользователя нет в meta_user
+	Timeout            int    `yaml:"timeout"`             // секунды
+}
+
+// JWTKey — ключ подписи токенов, выбирается по заголовку kid
+type JWTKey struct {
+	ID     string `yaml:"kid"`
+	Secret string `yaml:"secret"`
+}
+
+// SigningKey возвращает kid и секрет для подписи новых токенов.
+// Без jwt_keys используется jwt_secret с пустым kid.
+func (s SecurityConfig) SigningKey() (string, string) {
+	for _, k := range s.JWTKeys {
+		if k.ID == s.JWTActiveKey {
+			return k.ID, k.Secret
+		}
+	}
+	if len(s.JWTKeys) > 0 {
+		return s.JWTKeys[0].ID, s.JWTKeys[0].Secret
+	}
+	return "", s.JWTSecret
+}
+
+// VerificationKey возвращает секрет для проверки токена с указанным kid.
+// Токены без kid проверяются по jwt_secret.
+func (s SecurityConfig) VerificationKey(kid string) (string, bool) {
+	if kid == "" {
+		return s.JWTSecret, s.JWTSecret != ""
+	}
+	for _, k := range s.JWTKeys {
+		if k.ID == kid {
+			return k.Secret, k.Secret != ""
+		}
+	}
+	return "", false
 }
 
 func LoadConfig(path string) *Config {
@@ -41,5 +106,82 @@ func LoadConfig(path string) *Config {
 		log.Fatalf("Error parsing config file: %v", err)
 	}
 
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
 	return &cfg
 }
+
+// validate проверяет согласованность параметров
+func (c *Config) validate() error {
+	seen := make(map[string]bool)
+	for _, k := range c.Security.JWTKeys {
+		if k.ID == "" || k.Secret == "" {
+			return fmt.Errorf("security.jwt_keys: kid and secret are required")
+		}
+		if seen[k.ID] {
+			return fmt.Errorf("security.jwt_keys: duplicate kid %q", k.ID)
+		}
+		seen[k.ID] = true
+	}
+	if c.Security.JWTActiveKey != "" && !seen[c.Security.JWTActiveKey] {
+		return fmt.Errorf("security.jwt_active_key %q not found in jwt_keys", c.Security.JWTActiveKey)
+	}
+	if len(c.Security.JWTKeys) == 0 && c.Security.JWTSecret == "" {
+		return fmt.Errorf("security: jwt_secret or jwt_keys must be set")
+	}
+	for _, name := range c.Security.Authenticators {
+		switch name {
+		case "erp":
+		case "ldap":
+			if c.Security.LDAP.URL == "" || c.Security.LDAP.BaseDN == "" {
+				return fmt.Errorf("security.ldap: url and base_dn are required")
+			}
+		default:
+			return fmt.Errorf("security.authenticators: unknown authenticator %q", name)
+		}
+	}
+	return nil
+}
+
+// applyDefaults подставляет значения по умолчанию для незаполненных параметров
+func (c *Config) applyDefaults() {
+	if c.Security.AccessTokenTTL <= 0 {
+		c.Security.AccessTokenTTL = 15
+	}
+	if c.Security.RefreshTokenTTL <= 0 {
+		c.Security.RefreshTokenTTL = 168
+	}
+	if c.Security.TokenDenylist == "" {
+		c.Security.TokenDenylist = "sql"
+	}
+	if c.Security.LoginMaxAttempts <= 0 {
+		c.Security.LoginMaxAttempts = 5
+	}
+	if c.Security.LoginMaxAttemptsPerIP <= 0 {
+		c.Security.LoginMaxAttemptsPerIP = 20
+	}
+	if c.Security.LoginBackoffBase <= 0 {
+		c.Security.LoginBackoffBase = 1
+	}
+	if c.Security.LoginLockout <= 0 {
+		c.Security.LoginLockout = 15
+	}
+	if len(c.Security.Authenticators) == 0 {
+		c.Security.Authenticators = []string{"erp"}
+	}
+	if c.Security.LDAP.UserFilter == "" {
+		c.Security.LDAP.UserFilter = "(&(objectClass=user)(sAMAccountName=%s))"
+	}
+	if c.Security.LDAP.UsernameAttribute == "" {
+		c.Security.LDAP.UsernameAttribute = "sAMAccountName"
+	}
+	if c.Security.LDAP.FullNameAttribute == "" {
+		c.Security.LDAP.FullNameAttribute = "displayName"
+	}
+	if c.Security.LDAP.Timeout <= 0 {
+		c.Security.LDAP.Timeout = 5
+	}
+}
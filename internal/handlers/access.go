@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"RemainsManager/internal/middleware"
+	"RemainsManager/internal/models"
+	"RemainsManager/internal/services"
+)
+
+var guidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// isGUID проверяет формат ID_CONTRACTOR_GLOBAL и других глобальных идентификаторов
+func isGUID(s string) bool {
+	return guidPattern.MatchString(s)
+}
+
+// requestScope возвращает аптеки, доступные пользователю запроса.
+// При ошибке ответ уже записан, вызывающий должен просто вернуться.
+func requestScope(w http.ResponseWriter, r *http.Request, access *services.AccessService) (*models.ContractorScope, bool) {
+	claims, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	scope, err := access.Scope(r.Context(), claims)
+	if err != nil {
+		log.Printf("Failed to load access scope for %s: %v", claims.Subject, err)
+		http.Error(w, "Failed to check access", http.StatusInternalServerError)
+		return nil, false
+	}
+	return scope, true
+}
+
+// requireContractorAccess отвечает 403, если аптека не закреплена за пользователем
+func requireContractorAccess(w http.ResponseWriter, r *http.Request, access *services.AccessService, contractorIDs ...string) bool {
+	scope, ok := requestScope(w, r, access)
+	if !ok {
+		return false
+	}
+
+	for _, id := range contractorIDs {
+		if !scope.Allows(id) {
+			http.Error(w, "access to contractor "+id+" is denied", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// writeOfferLookupError отвечает 404 для несуществующей заявки или позиции и 500 в остальных случаях
+func writeOfferLookupError(w http.ResponseWriter, err error) {
+	if strings.Contains(err.Error(), "not found") {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Failed to load offer", http.StatusInternalServerError)
+}
+
+// writeOfferError отвечает 409, если действие недопустимо в текущем статусе заявки,
+// перемещение получателю уже сохранено или партии не хватает с учётом резервов (с доступными остатками в теле),
+// 422 со списком проблемных позиций, если заявка не прошла проверку складов и партий,
+// 404 для несуществующей заявки или позиции и 500 с префиксом message в остальных случаях
+func writeOfferError(w http.ResponseWriter, err error, message string) {
+	var validationErr *models.OfferValidationError
+	if errors.As(err, &validationErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":    validationErr.Error(),
+			"problems": validationErr.Problems,
+		})
+		return
+	}
+
+	var overErr *models.OverAllocationError
+	if errors.As(err, &overErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error": overErr.Error(),
+			"lots":  overErr.Lots,
+		})
+		return
+	}
+
+	var bulkErr *models.OfferBulkEditError
+	if errors.As(err, &bulkErr) {
+		status := http.StatusUnprocessableEntity
+		if len(bulkErr.Lots) > 0 {
+			status = http.StatusConflict
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error": bulkErr.Error(),
+			"items": bulkErr.Items,
+			"lots":  bulkErr.Lots,
+		})
+		return
+	}
+
+	var stateErr *models.OfferStateError
+	if errors.As(err, &stateErr) {
+		http.Error(w, stateErr.Error(), http.StatusConflict)
+		return
+	}
+	var savedErr *models.ReceiverSavedError
+	if errors.As(err, &savedErr) {
+		http.Error(w, savedErr.Error(), http.StatusConflict)
+		return
+	}
+	if strings.Contains(err.Error(), "not found") {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, message+": "+err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"RemainsManager/internal/middleware"
+	"RemainsManager/internal/models"
+	"RemainsManager/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type AdminHandler struct {
+	access  *services.AccessService
+	apiKeys *services.APIKeyService
+	audit   *services.AuditService
+}
+
+func NewAdminHandler(access *services.AccessService, apiKeys *services.APIKeyService, audit *services.AuditService) *AdminHandler {
+	return &AdminHandler{access: access, apiKeys: apiKeys, audit: audit}
+}
+
+// GetUserPharmacies godoc
+// @Summary		Аптеки пользователя
+// @Description	Возвращает ID_CONTRACTOR_GLOBAL аптек, закреплённых за пользователем
+// @Tags			admin
+// @Produce		json
+// @Param			name	path		string	true	"Имя пользователя (meta_user.NAME) или apikey:<имя ключа>"
+// @Success		200	{array}	string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/admin/users/{name}/pharmacies [get]
+func (h *AdminHandler) GetUserPharmacies(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	ids, err := h.access.GetUserContractors(r.Context(), name)
+	if err != nil {
+		http.Error(w, "Failed to fetch user pharmacies", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ids)
+}
+
+// SetUserPharmacies godoc
+// @Summary		Закрепить аптеки за пользователем
+// @Description	Заменяет список аптек пользователя; пустой список снимает все закрепления
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			name	path		string							true	"Имя пользователя (meta_user.NAME) или apikey:<имя ключа>"
+// @Param			body	body		models.UserContractorsRequest	true	"Аптеки"
+// @Success		200	{object}	map[string]string
+// @Failure		400	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/admin/users/{name}/pharmacies [put]
+func (h *AdminHandler) SetUserPharmacies(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	var req models.UserContractorsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, id := range req.ContractorIDs {
+		if !isGUID(id) {
+			http.Error(w, "invalid contractor id: "+id, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.access.SetUserContractors(r.Context(), name, req.ContractorIDs); err != nil {
+		http.Error(w, "Failed to update user pharmacies: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+// GetUserRoles godoc
+// @Summary		Роли пользователя
+// @Description	Возвращает роли пользователя; пустой список означает роль по умолчанию (viewer)
+// @Tags			admin
+// @Produce		json
+// @Param			name	path		string	true	"Имя пользователя (meta_user.NAME)"
+// @Success		200	{array}	string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/admin/users/{name}/roles [get]
+func (h *AdminHandler) GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	roles, err := h.access.GetUserRoles(r.Context(), name)
+	if err != nil {
+		http.Error(w, "Failed to fetch user roles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+// SetUserRoles godoc
+// @Summary		Назначить роли пользователю
+// @Description	Заменяет роли пользователя. Изменения применяются при следующем обновлении токена
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			name	path		string					true	"Имя пользователя (meta_user.NAME)"
+// @Param			body	body		models.UserRolesRequest	true	"Роли"
+// @Success		200	{object}	map[string]string
+// @Failure		400	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/admin/users/{name}/roles [put]
+func (h *AdminHandler) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	var req models.UserRolesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, role := range req.Roles {
+		if !models.IsValidRole(role) {
+			http.Error(w, "unknown role: "+role, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.access.SetUserRoles(r.Context(), name, req.Roles); err != nil {
+		http.Error(w, "Failed to update user roles: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+// CreateAPIKey godoc
+// @Summary		Создать API-ключ
+// @Description	Выпускает ключ сервисной учётной записи. Ключ возвращается только в этом ответе.
+// @Description	Ключ без разрешения pharmacies:all должен быть закреплён за аптеками (contractor_ids), иначе он не увидит ни одной.
+// @Description	Аптеки ключа меняются через /admin/users/apikey:<имя>/pharmacies
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			body	body		models.CreateAPIKeyRequest	true	"Имя и разрешения ключа"
+// @Success		201	{object}	models.CreatedAPIKey
+// @Failure		400	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/admin/api-keys [post]
+func (h *AdminHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Name) > models.APIKeyMaxNameLength {
+		http.Error(w, fmt.Sprintf("name must be at most %d characters", models.APIKeyMaxNameLength), http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "at least one scope is required", http.StatusBadRequest)
+		return
+	}
+	allPharmacies := false
+	for _, scope := range req.Scopes {
+		if !models.IsValidPermission(scope) {
+			http.Error(w, "unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+		if scope == models.PermissionAllPharmacies {
+			allPharmacies = true
+		}
+	}
+	for _, id := range req.ContractorIDs {
+		if !isGUID(id) {
+			http.Error(w, "invalid contractor id: "+id, http.StatusBadRequest)
+			return
+		}
+	}
+	if !allPharmacies && len(req.ContractorIDs) == 0 {
+		http.Error(w, "api key needs the "+models.PermissionAllPharmacies+" scope or at least one contractor_ids entry", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := middleware.UserFromContext(r.Context())
+	key, err := h.apiKeys.CreateAPIKey(r.Context(), req.Name, req.Scopes, req.ContractorIDs, claims.Subject)
+	if err != nil {
+		http.Error(w, "Failed to create api key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// GetAPIKeys godoc
+// @Summary		Список API-ключей
+// @Description	Возвращает все ключи, включая отозванные, без самих ключей
+// @Tags			admin
+// @Produce		json
+// @Success		200	{array}	models.APIKey
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/admin/api-keys [get]
+func (h *AdminHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.apiKeys.GetAPIKeys(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to fetch api keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey godoc
+// @Summary		Отозвать API-ключ
+// @Description	Ключ перестаёт приниматься сразу после отзыва
+// @Tags			admin
+// @Param			id	path		int	true	"ID ключа"
+// @Success		204
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/admin/api-keys/{id} [delete]
+func (h *AdminHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid api key ID", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := middleware.UserFromContext(r.Context())
+	if err := h.apiKeys.RevokeAPIKey(r.Context(), id, claims.Subject); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "Api key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke api key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAuditLog godoc
+// @Summary		Журнал аудита
+// @Description	Входы, неудачные входы и изменяющие вызовы /api за период, от новых к старым
+// @Tags			admin
+// @Produce		json
+// @Param			from	query		string	false	"Дата начала (YYYY-MM-DD), по умолчанию — 7 дней назад"
+// @Param			to		query		string	false	"Дата окончания включительно (YYYY-MM-DD), по умолчанию — сегодня"
+// @Param			user	query		string	false	"Имя пользователя или apikey:<имя ключа>"
+// @Param			action	query		string	false	"login | login_failed | request"
+// @Param			limit	query		int		false	"Максимум записей (по умолчанию 500, не более 5000)"
+// @Success		200	{array}	models.AuditEntry
+// @Failure		400	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/admin/audit [get]
+func (h *AdminHandler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	from, err := parseDate(q.Get("from"), 7)
+	if err != nil {
+		http.Error(w, "invalid 'from' date", http.StatusBadRequest)
+		return
+	}
+	to, err := parseDate(q.Get("to"), 0)
+	if err != nil {
+		http.Error(w, "invalid 'to' date", http.StatusBadRequest)
+		return
+	}
+	if from.After(to) {
+		http.Error(w, "'from' date must be before or equal to 'to'", http.StatusBadRequest)
+		return
+	}
+
+	filter := models.AuditFilter{
+		From:   from,
+		To:     to.Add(24 * time.Hour),
+		User:   q.Get("user"),
+		Action: q.Get("action"),
+		Limit:  500,
+	}
+	if limitStr := q.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 5000 {
+			http.Error(w, "limit must be between 1 and 5000", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	entries, err := h.audit.GetAuditLog(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"RemainsManager/internal/middleware"
 	"RemainsManager/internal/models"
 	"encoding/json"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"RemainsManager/internal/services"
 )
@@ -25,9 +30,10 @@ func NewAuthHandler(service *services.AuthService) *AuthHandler {
 //
 // @Param			body	body		models.AuthRequest	true	"Логин и пароль"
 //
-// @Success		200	{object}	map[string]string
+// @Success		200	{object}	models.TokenPair
 // @Failure		400	{object}	map[string]string
 // @Failure		401	{object}	map[string]string
+// @Failure		429	{object}	map[string]string
 //
 // @Router			/login [post]
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -38,12 +44,93 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.service.Authenticate(req.Username, req.Password)
+	tokens, err := h.service.Authenticate(r.Context(), req.Username, req.Password, middleware.ClientIP(r))
 	if err != nil {
-		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		var locked *services.LoginLockedError
+		switch {
+		case errors.As(err, &locked):
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			http.Error(w, "too many login attempts", http.StatusTooManyRequests)
+		case errors.Is(err, services.ErrInvalidCredentials):
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		default:
+			log.Printf("Login failed for %q: %v", req.Username, err)
+			http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+		}
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Refresh godoc
+// @Summary		Обновление токенов
+// @Description	Выдаёт новую пару токенов по refresh-токену; старый refresh-токен отзывается
+// @Tags			auth
+// @Accept			json
+// @Produce		json
+// @Param			body	body		models.RefreshRequest	true	"Refresh-токен"
+// @Success		200	{object}	models.TokenPair
+// @Failure		400	{object}	map[string]string
+// @Failure		401	{object}	map[string]string
+// @Router			/refresh [post]
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.RefreshToken == "" {
+		http.Error(w, "missing refresh_token", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.service.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidToken) {
+			http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout godoc
+// @Summary		Выход из системы
+// @Description	Отзывает текущий токен доступа и переданный refresh-токен
+// @Tags			auth
+// @Accept			json
+// @Param			body	body		models.RefreshRequest	false	"Refresh-токен"
+// @Success		204
+// @Failure		401	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/logout [post]
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Тело необязательно: без него отзывается только токен доступа
+	var req models.RefreshRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	if err := h.service.Logout(r.Context(), claims, req.RefreshToken); err != nil {
+		if errors.Is(err, services.ErrInvalidToken) {
+			http.Error(w, "refresh token belongs to another user", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to logout", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -1,35 +1,67 @@
 package handlers
 
 import (
+	"RemainsManager/internal/middleware"
 	"RemainsManager/internal/models"
 	"RemainsManager/internal/services"
+	"archive/zip"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type OfferHandler struct {
 	service               *services.OfferService
 	autoDistributeService *services.AutoDistributeService
+	access                *services.AccessService
+	jobs                  *services.OfferJobService
 }
 
 func NewOfferHandler(
 	service *services.OfferService,
 	autoDistributeService *services.AutoDistributeService,
+	access *services.AccessService,
+	jobs *services.OfferJobService,
 ) *OfferHandler {
 	return &OfferHandler{
 		service:               service,
 		autoDistributeService: autoDistributeService,
+		access:                access,
+		jobs:                  jobs,
 	}
 }
 
+// requireOfferAccess проверяет, что отправитель заявки закреплён за пользователем
+func (h *OfferHandler) requireOfferAccess(w http.ResponseWriter, r *http.Request, offerID int64) bool {
+	offer, err := h.service.GetOffer(r.Context(), offerID)
+	if err != nil {
+		writeOfferLookupError(w, err)
+		return false
+	}
+	return requireContractorAccess(w, r, h.access, offer.IdContractorGlobalFrom)
+}
+
+// requireOfferItemAccess проверяет доступ к заявке, которой принадлежит позиция
+func (h *OfferHandler) requireOfferItemAccess(w http.ResponseWriter, r *http.Request, itemID int64) bool {
+	offer, err := h.service.GetOfferByItem(r.Context(), itemID)
+	if err != nil {
+		writeOfferLookupError(w, err)
+		return false
+	}
+	return requireContractorAccess(w, r, h.access, offer.IdContractorGlobalFrom)
+}
+
 // GetOrCreateOffer godoc
 // @Summary		Получить или создать заявку на сегодня
-// @Description	Возвращает активную заявку или создаёт новую
+// @Description	Возвращает активную заявку по умолчанию на сегодня или создаёт новую; другие черновики — /offers/drafts
 // @Tags			offers
 // @Accept			json
 // @Produce		json
@@ -49,6 +81,10 @@ func (h *OfferHandler) GetOrCreateOffer(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !requireContractorAccess(w, r, h.access, fromID) {
+		return
+	}
+
 	offer, err := h.service.GetOrCreateTodayOffer(r.Context(), fromID, fromName)
 	if err != nil {
 		http.Error(w, "Failed to get or create offer", http.StatusInternalServerError)
@@ -59,15 +95,100 @@ func (h *OfferHandler) GetOrCreateOffer(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(offer)
 }
 
+// CreateDraft godoc
+// @Summary		Создать именованный черновик заявки
+// @Description	Создаёт ещё одну заявку отправителя, например на отдельный маршрут или вторую партию за день.
+// @Description	Заявка "на сегодня" (GET /offer) при этом не меняется
+// @Tags			offers
+// @Accept			json
+// @Produce		json
+// @Param			body	body		models.CreateDraftRequest	true	"Отправитель и название"
+// @Success		201	{object}	models.Offer
+// @Failure		400	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers [post]
+func (h *OfferHandler) CreateDraft(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateDraftRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if !isGUID(req.FromID) {
+		http.Error(w, "invalid from_id", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > 255 {
+		http.Error(w, "name must be between 1 and 255 characters", http.StatusBadRequest)
+		return
+	}
+
+	if !requireContractorAccess(w, r, h.access, req.FromID) {
+		return
+	}
+
+	offer, err := h.service.CreateDraft(r.Context(), req.FromID, req.Name)
+	if err != nil {
+		log.Printf("Failed to create draft for %s: %v", req.FromID, err)
+		http.Error(w, "Failed to create offer", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(offer)
+}
+
+// GetOpenDrafts godoc
+// @Summary		Открытые черновики отправителя
+// @Description	Заявки отправителя, которые ещё можно редактировать (новые, отправленные, с ошибкой), от новых к старым
+// @Tags			offers
+// @Produce		json
+// @Param			from_id	query		string	true	"ID контрагента-отправителя"
+// @Success		200	{array}	models.OfferDraft
+// @Failure		400	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/drafts [get]
+func (h *OfferHandler) GetOpenDrafts(w http.ResponseWriter, r *http.Request) {
+	fromID := r.URL.Query().Get("from_id")
+	if !isGUID(fromID) {
+		http.Error(w, "invalid from_id", http.StatusBadRequest)
+		return
+	}
+
+	if !requireContractorAccess(w, r, h.access, fromID) {
+		return
+	}
+
+	drafts, err := h.service.GetOpenDrafts(r.Context(), fromID)
+	if err != nil {
+		log.Printf("Failed to get drafts of %s: %v", fromID, err)
+		http.Error(w, "Failed to fetch drafts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drafts)
+}
+
 // AddOfferItems godoc
 // @Summary		Добавить несколько позиций в заявку
-// @Description	Добавляет массив товаров в текущую заявку
+// @Description	Добавляет массив товаров в текущую заявку.
+// @Description	409 — заявка не редактируется, перемещение получателю уже сохранено или в партии не хватает остатка с учётом резервов других заявок (доступное количество в lots)
+// @Description	422 — отправитель позиции не совпадает с отправителем заявки или партия лежит не на его складах (список позиций в problems)
 // @Tags			offers
 // @Accept			json
 // @Produce		json
 // @Param			body	body		[]models.OfferItem	true	"Массив позиций заявки"
 // @Success		200	{object}	map[string]string
 // @Failure		400	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
+// @Failure		422	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
 // @Security		ApiKeyAuth
 // @Router			/offer-items/bulk [post]
@@ -83,15 +204,32 @@ func (h *OfferHandler) AddOfferItems(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	offerIDs := make(map[int64]bool)
+	senders := make([]string, 0, len(items))
 	for _, item := range items {
 		if item.OfferID == 0 {
 			http.Error(w, "offer_id is required for all items", http.StatusBadRequest)
 			return
 		}
+		if item.Quantity <= 0 {
+			http.Error(w, "quantity must be greater than 0 for all items", http.StatusBadRequest)
+			return
+		}
+		offerIDs[item.OfferID] = true
+		senders = append(senders, item.IdContractorGlobalFrom)
+	}
+
+	if !requireContractorAccess(w, r, h.access, senders...) {
+		return
+	}
+	for offerID := range offerIDs {
+		if !h.requireOfferAccess(w, r, offerID) {
+			return
+		}
 	}
 
 	if err := h.service.AddItems(r.Context(), items); err != nil {
-		http.Error(w, "Failed to add items: "+err.Error(), http.StatusInternalServerError)
+		writeOfferError(w, err, "Failed to add items")
 		return
 	}
 
@@ -101,12 +239,20 @@ func (h *OfferHandler) AddOfferItems(w http.ResponseWriter, r *http.Request) {
 
 // GetOfferJournal godoc
 // @Summary		Получить журнал заявок
-// @Description	Возвращает список заявок с фильтрацией по дате и контрагенту
+// @Description	Возвращает страницу журнала заявок с размером каждой заявки (позиции, получатели, количество, сумма в рознице).
+// @Description	Общее число заявок по фильтру — в заголовке X-Total-Count, число страниц — в X-Total-Pages.
 // @Tags			offers
 // @Produce		json
 // @Param			from				query		string	false	"Дата начала (YYYY-MM-DD)"	default(2025-01-01)
 // @Param			to					query		string	false	"Дата окончания (YYYY-MM-DD)"	default(2025-12-31)
 // @Param			contractor_id		query		string	false	"ID контрагента-отправителя (GUID)"
+// @Param			status				query		string	false	"Статусы через запятую (0-4)"
+// @Param			receiver_id			query		string	false	"ID контрагента-получателя (GUID)"
+// @Param			search				query		string	false	"Поиск по названию заявки"
+// @Param			sort				query		string	false	"Сортировка"	Enums(date, sender, total)	default(date)
+// @Param			order				query		string	false	"Направление"	Enums(asc, desc)	default(desc)
+// @Param			page				query		int		false	"Номер страницы"	default(1)
+// @Param			limit				query		int		false	"Размер страницы"	default(50)
 // @Success		200	{array}	models.OfferJournalItem
 // @Failure		400	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
@@ -116,6 +262,12 @@ func (h *OfferHandler) GetOfferJournal(w http.ResponseWriter, r *http.Request) {
 	fromStr := r.URL.Query().Get("from")
 	toStr := r.URL.Query().Get("to")
 	contractorID := r.URL.Query().Get("contractor_id")
+	receiverID := r.URL.Query().Get("receiver_id")
+	search := strings.TrimSpace(r.URL.Query().Get("search"))
+	sortBy := r.URL.Query().Get("sort")
+	order := r.URL.Query().Get("order")
+	pageStr := r.URL.Query().Get("page")
+	limitStr := r.URL.Query().Get("limit")
 
 	from, err := parseDate(fromStr, 7) // по умолчанию — 7 дней назад
 	if err != nil {
@@ -134,21 +286,105 @@ func (h *OfferHandler) GetOfferJournal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var contractorPtr *string
-	if contractorID != "" {
-		contractorPtr = &contractorID
+	statuses, err := parseStatusList(r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if receiverID != "" && !isGUID(receiverID) {
+		http.Error(w, "invalid 'receiver_id'", http.StatusBadRequest)
+		return
+	}
+
+	if sortBy == "" {
+		sortBy = models.JournalSortDate
+	}
+	switch sortBy {
+	case models.JournalSortDate, models.JournalSortSender, models.JournalSortTotal:
+	default:
+		http.Error(w, "invalid 'sort': expected date, sender or total", http.StatusBadRequest)
+		return
+	}
+
+	if order != "" && order != "asc" && order != "desc" {
+		http.Error(w, "invalid 'order': expected asc or desc", http.StatusBadRequest)
+		return
+	}
+
+	page := 1
+	limit := 50
+	if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+		page = p
+	}
+	if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxJournalPageSize {
+		limit = maxJournalPageSize
+	}
+
+	scope, ok := requestScope(w, r, h.access)
+	if !ok {
+		return
+	}
+
+	if contractorID != "" && !scope.Allows(contractorID) {
+		http.Error(w, "access to contractor "+contractorID+" is denied", http.StatusForbidden)
+		return
+	}
+
+	filter := models.OfferJournalFilter{
+		From:       from,
+		To:         to,
+		Contractor: contractorID,
+		Statuses:   statuses,
+		Receiver:   receiverID,
+		Search:     search,
+		Sort:       sortBy,
+		Desc:       order != "asc",
+		Page:       page,
+		Limit:      limit,
+	}
+	// Без доступа ко всей сети журнал ограничен закреплёнными аптеками
+	if !scope.All {
+		filter.Allowed = scope.ContractorIDs
 	}
 
-	items, err := h.service.GetOfferJournal(r.Context(), from, to, contractorPtr)
+	items, total, err := h.service.GetOfferJournal(r.Context(), filter)
 	if err != nil {
 		http.Error(w, "Failed to fetch journal", http.StatusInternalServerError)
 		return
 	}
 
+	totalPages := (total + limit - 1) / limit
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("X-Total-Pages", strconv.Itoa(totalPages))
+	w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Total-Pages")
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(items)
 }
 
+// maxJournalPageSize — наибольший размер страницы журнала заявок
+const maxJournalPageSize = 1000
+
+// parseStatusList разбирает список статусов заявки через запятую
+func parseStatusList(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var statuses []int
+	for _, part := range strings.Split(s, ",") {
+		st, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || st < models.OfferStatusNew || st > models.OfferStatusDeleted {
+			return nil, fmt.Errorf("invalid 'status': %q", part)
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
 // Вспомогательная функция парсинга даты
 func parseDate(dateStr string, daysAgo int) (time.Time, error) {
 	if dateStr == "" {
@@ -160,10 +396,14 @@ func parseDate(dateStr string, daysAgo int) (time.Time, error) {
 
 // GetOfferDetails godoc
 // @Summary		Получить детали заявки
-// @Description	Возвращает список позиций указанной заявки
+// @Description	Возвращает позиции заявки с партией, ценами, сроком годности, остатком отправителя,
+// @Description	а также остатком товара и скоростью продаж получателя за days дней.
+// @Description	С group_by=receiver позиции группируются по получателям (массив models.OfferReceiverDetails).
 // @Tags			offers
 // @Produce		json
-// @Param			id	path		int	true	"ID заявки"
+// @Param			id			path		int		true	"ID заявки"
+// @Param			days		query		int		false	"Период продаж получателя, дней"	default(30)
+// @Param			group_by	query		string	false	"Группировка"	Enums(receiver)
 // @Success		200	{array}	models.OfferDetailItem
 // @Failure		400	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
@@ -177,19 +417,39 @@ func (h *OfferHandler) GetOfferDetails(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	items, err := h.service.GetOfferDetails(r.Context(), id)
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	days := 30
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 {
+		days = d
+	}
+
+	var result interface{}
+	switch groupBy := r.URL.Query().Get("group_by"); groupBy {
+	case "":
+		result, err = h.service.GetOfferDetails(r.Context(), id, days)
+	case "receiver":
+		result, err = h.service.GetOfferDetailsByReceiver(r.Context(), id, days)
+	default:
+		http.Error(w, "invalid 'group_by': expected receiver", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch details", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(items)
+	json.NewEncoder(w).Encode(result)
 }
 
 // UpdateOfferItem godoc
 // @Summary		Обновить количество в позиции заявки
-// @Description	Изменяет количество товара в существующей позиции
+// @Description	Изменяет количество товара в существующей позиции.
+// @Description	Увеличение сверх остатка партии за вычетом резервов других заявок отклоняется с 409
+// @Description	Позиции получателя, перемещение которому уже сохранено, не меняются (409)
 // @Tags			offers
 // @Accept			json
 // @Produce		json
@@ -198,6 +458,7 @@ func (h *OfferHandler) GetOfferDetails(w http.ResponseWriter, r *http.Request) {
 // @Success		200	{object}	map[string]string
 // @Failure		400	{object}	map[string]string
 // @Failure		404	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
 // @Security		ApiKeyAuth
 // @Router			/offer-items/{id} [put]
@@ -209,6 +470,10 @@ func (h *OfferHandler) UpdateOfferItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.requireOfferItemAccess(w, r, id) {
+		return
+	}
+
 	var req UpdateQuantityRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -221,11 +486,7 @@ func (h *OfferHandler) UpdateOfferItem(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.service.UpdateOfferItem(r.Context(), id, req.Quantity); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, "Offer item not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Failed to update item: "+err.Error(), http.StatusInternalServerError)
+		writeOfferError(w, err, "Failed to update item")
 		return
 	}
 
@@ -240,11 +501,12 @@ type UpdateQuantityRequest struct {
 
 // DeleteOfferItem godoc
 // @Summary		Удалить позицию из заявки
-// @Description	Удаляет позицию по ID
+// @Description	Удаляет позицию по ID. Позиции получателя, перемещение которому уже сохранено, не удаляются (409)
 // @Tags			offers
 // @Param			id	path		int	true	"ID позиции заявки"
 // @Success		204
 // @Failure		404	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
 // @Security		ApiKeyAuth
 // @Router			/offer-items/{id} [delete]
@@ -256,25 +518,225 @@ func (h *OfferHandler) DeleteOfferItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.requireOfferItemAccess(w, r, id) {
+		return
+	}
+
 	if err := h.service.DeleteOfferItem(r.Context(), id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, "Offer item not found", http.StatusNotFound)
+		writeOfferError(w, err, "Failed to delete item")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteReceiverItems godoc
+// @Summary		Удалить все позиции получателя
+// @Description	Удаляет из заявки все позиции указанного получателя одной транзакцией и возвращает итог по каждой позиции
+// @Tags			offers
+// @Produce		json
+// @Param			id			path		int		true	"ID заявки"
+// @Param			receiverId	path		string	true	"ID контрагента-получателя (GUID)"
+// @Success		200	{object}	models.OfferBulkResult
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/receivers/{receiverId}/items [delete]
+func (h *OfferHandler) DeleteReceiverItems(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.offerForItemsEdit(w, r)
+	if !ok {
+		return
+	}
+
+	receiverID := chi.URLParam(r, "receiverId")
+	if !isGUID(receiverID) {
+		http.Error(w, "invalid receiver ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.DeleteReceiverItems(r.Context(), id, receiverID)
+	if err != nil {
+		writeOfferError(w, err, "Failed to delete receiver items")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// MoveOfferItems godoc
+// @Summary		Перенести позиции другому получателю
+// @Description	Переносит позиции item_ids или, если список пуст, все позиции получателя from_id получателю to_id.
+// @Description	Если заданы и item_ids, и from_id, позиции других получателей не переносятся (status=failed).
+// @Description	Строка той же партии, уже заказанная получателю to_id, объединяется с переносимой (status=merged).
+// @Description	Выполняется одной транзакцией: при ошибке хоть одной позиции ничего не меняется (422 с итогом по позициям)
+// @Tags			offers
+// @Accept			json
+// @Produce		json
+// @Param			id		path		int						true	"ID заявки"
+// @Param			body	body		models.MoveItemsRequest	true	"Что и кому перенести"
+// @Success		200	{object}	models.OfferBulkResult
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
+// @Failure		422	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/items/move [post]
+func (h *OfferHandler) MoveOfferItems(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.offerForItemsEdit(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.MoveItemsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !isGUID(req.ToReceiver) {
+		http.Error(w, "to_id must be a contractor GUID", http.StatusBadRequest)
+		return
+	}
+	if len(req.ItemIDs) == 0 && !isGUID(req.FromReceiver) {
+		http.Error(w, "either item_ids or from_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.FromReceiver != "" && !isGUID(req.FromReceiver) {
+		http.Error(w, "from_id must be a contractor GUID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.MoveItems(r.Context(), id, req)
+	if err != nil {
+		writeOfferError(w, err, "Failed to move items")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ScaleOfferItems godoc
+// @Summary		Пересчитать количества в процентах
+// @Description	Умножает количество каждой позиции (или только позиций receiver_id) на percent/100 с округлением, не меньше 1.
+// @Description	Выполняется одной транзакцией; если партиям не хватает остатка с учётом резервов — 409 и ничего не меняется
+// @Tags			offers
+// @Accept			json
+// @Produce		json
+// @Param			id		path		int							true	"ID заявки"
+// @Param			body	body		models.ScaleItemsRequest	true	"Процент от текущего количества"
+// @Success		200	{object}	models.OfferBulkResult
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/items/scale [post]
+func (h *OfferHandler) ScaleOfferItems(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.offerForItemsEdit(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.ScaleItemsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Percent <= 0 {
+		http.Error(w, "percent must be greater than 0", http.StatusBadRequest)
+		return
+	}
+	if req.ReceiverID != "" && !isGUID(req.ReceiverID) {
+		http.Error(w, "invalid receiver_id", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.ScaleItems(r.Context(), id, req)
+	if err != nil {
+		writeOfferError(w, err, "Failed to scale items")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// PatchOfferItems godoc
+// @Summary		Применить список правок позиций
+// @Description	Каждая правка меняет количество и/или получателя позиции либо удаляет её (delete=true).
+// @Description	Выполняется одной транзакцией: при ошибке хоть одной правки ничего не меняется
+// @Description	(422 с итогом по позициям; 409 — не хватает остатка партий с учётом резервов)
+// @Tags			offers
+// @Accept			json
+// @Produce		json
+// @Param			id		path		int							true	"ID заявки"
+// @Param			body	body		models.PatchItemsRequest	true	"Правки позиций"
+// @Success		200	{object}	models.OfferBulkResult
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
+// @Failure		422	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/items [patch]
+func (h *OfferHandler) PatchOfferItems(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.offerForItemsEdit(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.PatchItemsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Patches) == 0 {
+		http.Error(w, "no patches provided", http.StatusBadRequest)
+		return
+	}
+	for _, p := range req.Patches {
+		if p.ContractorTo != nil && !isGUID(*p.ContractorTo) {
+			http.Error(w, "id_contractor_global_to must be a contractor GUID", http.StatusBadRequest)
 			return
 		}
-		http.Error(w, "Failed to delete item: "+err.Error(), http.StatusInternalServerError)
+	}
+
+	result, err := h.service.PatchItems(r.Context(), id, req.Patches)
+	if err != nil {
+		writeOfferError(w, err, "Failed to patch items")
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// offerForItemsEdit разбирает ID заявки из пути и проверяет доступ к ней
+func (h *OfferHandler) offerForItemsEdit(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, h.requireOfferAccess(w, r, id)
 }
 
 // MarkOfferAsSent godoc
 // @Summary		Отметить заявку как отправленную
 // @Description	Меняет статус заявки на "отправлено" (1)
 // @Tags			offers
+// @Param			body	body		models.OfferStatusRequest	false	"Комментарий к смене статуса"
 // @Success		200	{object}	map[string]string
 // @Failure		400	{object}	map[string]string
 // @Failure		404	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
 // @Security		ApiKeyAuth
 // @Router			/offers/{id}/send [put]
@@ -286,12 +748,24 @@ func (h *OfferHandler) MarkOfferAsSent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.MarkAsSent(r.Context(), id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, "Offer not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Failed to mark as sent: "+err.Error(), http.StatusInternalServerError)
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment, err := decodeStatusComment(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.MarkAsSent(r.Context(), id, user.Subject, comment); err != nil {
+		writeOfferError(w, err, "Failed to mark as sent")
 		return
 	}
 
@@ -303,9 +777,11 @@ func (h *OfferHandler) MarkOfferAsSent(w http.ResponseWriter, r *http.Request) {
 // @Summary		Пометить заявку как удалённую
 // @Description	Логическое удаление: меняет статус на "удалена" (4)
 // @Tags			offers
+// @Param			body	body		models.OfferStatusRequest	false	"Комментарий к смене статуса"
 // @Success		200	{object}	map[string]string
 // @Failure		400	{object}	map[string]string
 // @Failure		404	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
 // @Failure		500	{object}	map[string]string
 // @Security		ApiKeyAuth
 // @Router			/offers/{id} [delete]
@@ -317,12 +793,24 @@ func (h *OfferHandler) DeleteOffer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.DeleteOffer(r.Context(), id); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, "Offer not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Failed to delete offer: "+err.Error(), http.StatusInternalServerError)
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment, err := decodeStatusComment(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeleteOffer(r.Context(), id, user.Subject, comment); err != nil {
+		writeOfferError(w, err, "Failed to delete offer")
 		return
 	}
 
@@ -332,12 +820,17 @@ func (h *OfferHandler) DeleteOffer(w http.ResponseWriter, r *http.Request) {
 
 // ProcessOffer godoc
 // @Summary		Обработать заявку и создать межфирменные перемещения
-// @Description	Генерирует и сохраняет документы перемещения на основе заявки
+// @Description	Ставит в фон генерацию и сохранение документов перемещения и сразу возвращает задание (202).
+// @Description	Ход обработки по получателям, ошибки и результат — GET /jobs/{jobId}.
+// @Description	Если заявка уже обрабатывается, возвращается текущее задание; для отработанной заявки результатом будут ранее созданные документы.
+// @Description	Удалённая заявка сразу отклоняется с 409
 // @Tags			offers
-// @Success		200	{object}	map[string]string
+// @Param			body	body		models.OfferStatusRequest	false	"Комментарий к смене статуса"
+// @Success		202	{object}	models.OfferJob
 // @Failure		400	{object}	map[string]string
 // @Failure		404	{object}	map[string]string
-// @Failure		500	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
+// @Failure		503	{object}	map[string]string
 // @Security		ApiKeyAuth
 // @Router			/offers/{id}/process [post]
 func (h *OfferHandler) ProcessOffer(w http.ResponseWriter, r *http.Request) {
@@ -348,15 +841,470 @@ func (h *OfferHandler) ProcessOffer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.ProcessOffer(r.Context(), id); err != nil {
-		log.Printf("Failed to process offer %d: %v", id, err)
-		http.Error(w, "Failed to process offer: "+err.Error(), http.StatusInternalServerError)
+	if !h.requireOfferAccess(w, r, id) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "processed"})
-}
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment, err := decodeStatusComment(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Недопустимый статус отклоняем сразу, а не внутри задания
+	offer, err := h.service.GetOffer(r.Context(), id)
+	if err != nil {
+		writeOfferLookupError(w, err)
+		return
+	}
+	if offer.Status != models.OfferStatusProcessed && !models.CanTransitionOffer(offer.Status, models.OfferStatusProcessed) {
+		writeOfferError(w, &models.OfferStateError{OfferID: id, Status: offer.Status, Action: "process"}, "")
+		return
+	}
+
+	job, err := h.jobs.StartProcessing(id, user.User(), comment)
+	if errors.Is(err, services.ErrJobsShuttingDown) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start processing of offer %d: %v", id, err)
+		http.Error(w, "Failed to start processing", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// RetryOffer godoc
+// @Summary		Повторить обработку заявки с ошибкой
+// @Description	Ставит в фон повторную обработку заявки в статусе "ошибка": сохраняются только получатели,
+// @Description	перемещения которым не удалось сохранить раньше. Ход — GET /jobs/{jobId}
+// @Tags			offers
+// @Param			id		path		int							true	"ID заявки"
+// @Param			body	body		models.OfferStatusRequest	false	"Комментарий к смене статуса"
+// @Success		202	{object}	models.OfferJob
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		409	{object}	map[string]string
+// @Failure		503	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/retry [post]
+func (h *OfferHandler) RetryOffer(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment, err := decodeStatusComment(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	offer, err := h.service.GetOffer(r.Context(), id)
+	if err != nil {
+		writeOfferLookupError(w, err)
+		return
+	}
+	if offer.Status != models.OfferStatusError {
+		writeOfferError(w, &models.OfferStateError{OfferID: id, Status: offer.Status, Action: "retry"}, "")
+		return
+	}
+
+	job, err := h.jobs.StartRetry(id, user.User(), comment)
+	if errors.Is(err, services.ErrJobsShuttingDown) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start retry of offer %d: %v", id, err)
+		http.Error(w, "Failed to start processing", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetReceiverOutcomes godoc
+// @Summary		Итоги обработки заявки по получателям
+// @Description	Последняя попытка по каждому получателю: saved или failed, число попыток и ошибка
+// @Tags			offers
+// @Produce		json
+// @Param			id	path		int	true	"ID заявки"
+// @Success		200	{array}	models.ReceiverOutcome
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/receivers [get]
+func (h *OfferHandler) GetReceiverOutcomes(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	outcomes, err := h.service.GetReceiverOutcomes(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to get receiver outcomes of offer %d: %v", id, err)
+		http.Error(w, "Failed to fetch receiver outcomes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outcomes)
+}
+
+// GetJob godoc
+// @Summary		Задание обработки заявки
+// @Description	Статус задания (queued, running, succeeded, failed), текущий этап, состояние перемещения
+// @Description	по каждому получателю, ошибка, проблемные позиции и результат. Завершённые задания хранятся час
+// @Tags			offers
+// @Produce		json
+// @Param			jobId	path		string	true	"ID задания"
+// @Success		200	{object}	models.OfferJob
+// @Failure		403	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/jobs/{jobId} [get]
+func (h *OfferHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobId")
+
+	job, ok := h.jobs.GetJob(jobID)
+	if !ok {
+		http.Error(w, "job "+jobID+" not found", http.StatusNotFound)
+		return
+	}
+
+	if !h.requireOfferAccess(w, r, job.OfferID) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// PreviewOffer godoc
+// @Summary		Пробная обработка заявки
+// @Description	Показывает, какие перемещения создаст обработка: группировку по получателям, склады MAIN/TRS/TRR,
+// @Description	количества, суммы и XML. В ERP ничего не сохраняется; строки, которые не удастся провести, перечислены в failed_rows.
+// @Description	Если не хватает складов или партий, заполняется только item_problems
+// @Tags			offers
+// @Produce		json
+// @Param			id	path		int	true	"ID заявки"
+// @Success		200	{object}	models.OfferPreview
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/preview [get]
+func (h *OfferHandler) PreviewOffer(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	preview, err := h.service.PreviewOffer(r.Context(), id, user.User())
+	if err != nil {
+		log.Printf("Failed to preview offer %d: %v", id, err)
+		writeOfferError(w, err, "Failed to preview offer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// ValidateOffer godoc
+// @Summary		Проверка заявки перед обработкой
+// @Description	Проверяет склады TRS отправителя, MAIN и TRR получателя, наличие партий, их владельца и остаток.
+// @Description	Возвращает только позиции с проблемами; пустой список — заявку можно обрабатывать
+// @Tags			offers
+// @Produce		json
+// @Param			id	path		int	true	"ID заявки"
+// @Success		200	{array}	models.OfferItemProblem
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/validate [get]
+func (h *OfferHandler) ValidateOffer(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	problems, err := h.service.ValidateOffer(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to validate offer %d: %v", id, err)
+		writeOfferError(w, err, "Failed to validate offer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(problems)
+}
+
+// GetOfferMovings godoc
+// @Summary		Перемещения по заявке
+// @Description	Межфирменные перемещения, созданные при обработке заявки, по одному на получателя
+// @Tags			offers
+// @Produce		json
+// @Param			id	path		int	true	"ID заявки"
+// @Success		200	{array}	models.OfferMoving
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/movings [get]
+func (h *OfferHandler) GetOfferMovings(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	movings, err := h.service.GetOfferMovings(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to fetch offer movings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(movings)
+}
+
+// GetOfferDocuments godoc
+// @Summary		XML-документы заявки
+// @Description	Документы перемещения, сформированные при каждой попытке обработки заявки, от новых к старым.
+// @Description	saved=false — документ не был принят ERP
+// @Tags			offers
+// @Produce		json
+// @Param			id	path		int	true	"ID заявки"
+// @Success		200	{array}	models.OfferDocument
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/documents [get]
+func (h *OfferHandler) GetOfferDocuments(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	docs, err := h.service.GetOfferDocuments(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to get documents of offer %d: %v", id, err)
+		http.Error(w, "Failed to fetch offer documents", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(docs)
+}
+
+// DownloadOfferDocument godoc
+// @Summary		Скачать XML-документ
+// @Description	XML межфирменного перемещения в формате USP_INTERFIRM_MOVING_SAVE для ручной загрузки в ERP
+// @Tags			offers
+// @Produce		xml
+// @Param			id			path		int	true	"ID заявки"
+// @Param			documentId	path		int	true	"ID документа"
+// @Success		200	{file}		file
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/documents/{documentId} [get]
+func (h *OfferHandler) DownloadOfferDocument(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+		return
+	}
+	documentID, err := strconv.ParseInt(chi.URLParam(r, "documentId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid document ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	doc, err := h.service.GetOfferDocument(r.Context(), id, documentID)
+	if err != nil {
+		log.Printf("Failed to get document %d of offer %d: %v", documentID, id, err)
+		writeOfferLookupError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, offerDocumentFileName(doc)))
+	io.WriteString(w, doc.XML)
+}
+
+// DownloadOfferDocumentsArchive godoc
+// @Summary		Скачать XML-документы заявки архивом
+// @Description	ZIP с последним документом по каждому получателю
+// @Tags			offers
+// @Produce		application/zip
+// @Param			id	path		int	true	"ID заявки"
+// @Success		200	{file}		file
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/documents/archive [get]
+func (h *OfferHandler) DownloadOfferDocumentsArchive(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	docs, err := h.service.GetLatestOfferDocuments(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to get documents of offer %d: %v", id, err)
+		http.Error(w, "Failed to fetch offer documents", http.StatusInternalServerError)
+		return
+	}
+	if len(docs) == 0 {
+		http.Error(w, fmt.Sprintf("offer %d has no generated documents", id), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="offer_%d_documents.zip"`, id))
+
+	zw := zip.NewWriter(w)
+	for i := range docs {
+		f, err := zw.Create(offerDocumentFileName(&docs[i]))
+		if err == nil {
+			_, err = io.WriteString(f, docs[i].XML)
+		}
+		if err != nil {
+			log.Printf("Failed to write documents archive of offer %d: %v", id, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Failed to write documents archive of offer %d: %v", id, err)
+	}
+}
+
+// offerDocumentFileName — имя файла документа: заявка, получатель и GUID перемещения
+func offerDocumentFileName(doc *models.OfferDocument) string {
+	return fmt.Sprintf("offer_%d_%s_%s.xml", doc.OfferID, doc.ContractorTo, doc.MovingGlobal)
+}
+
+// GetOfferHistory godoc
+// @Summary		История статусов заявки
+// @Description	Кто, когда и почему менял статус заявки; для ошибок — текст ошибки
+// @Tags			offers
+// @Produce		json
+// @Param			id	path		int	true	"ID заявки"
+// @Success		200	{array}	models.OfferStatusChange
+// @Failure		400	{object}	map[string]string
+// @Failure		404	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/offers/{id}/history [get]
+func (h *OfferHandler) GetOfferHistory(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid offer ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.requireOfferAccess(w, r, id) {
+		return
+	}
+
+	history, err := h.service.GetOfferStatusHistory(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to fetch offer history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+// decodeStatusComment читает необязательный комментарий к смене статуса; пустое тело допустимо
+func decodeStatusComment(r *http.Request) (string, error) {
+	var req models.OfferStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return "", err
+	}
+	return req.Comment, nil
+}
 
 // AutoDistribute godoc
 // @Summary		Автоматически сформировать заявку по скорости продаж
@@ -386,13 +1334,17 @@ func (h *OfferHandler) AutoDistribute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireContractorAccess(w, r, h.access, req.ContractorGlobalFrom) {
+		return
+	}
+
 	if req.Days == 0 {
 		req.Days = 30
 	}
 
 	if err := h.autoDistributeService.Distribute(r.Context(), req.ContractorGlobalFrom, req.Days); err != nil {
 		log.Printf("Auto distribution failed: %v", err)
-		http.Error(w, "Failed to distribute: "+err.Error(), http.StatusInternalServerError)
+		writeOfferError(w, err, "Failed to distribute")
 		return
 	}
 
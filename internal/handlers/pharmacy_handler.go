@@ -4,24 +4,40 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"RemainsManager/internal/models"
 	"RemainsManager/internal/services"
 )
 
 type PharmacyHandler struct {
 	service *services.PharmacyService
+	access  *services.AccessService
 }
 
-func NewPharmacyHandler(service *services.PharmacyService) *PharmacyHandler {
-	return &PharmacyHandler{service: service}
+func NewPharmacyHandler(service *services.PharmacyService, access *services.AccessService) *PharmacyHandler {
+	return &PharmacyHandler{service: service, access: access}
 }
 
 func (h *PharmacyHandler) GetPharmacies(w http.ResponseWriter, r *http.Request) {
+	scope, ok := requestScope(w, r, h.access)
+	if !ok {
+		return
+	}
+
 	pharmacies, err := h.service.GetPharmacies()
 	if err != nil {
 		http.Error(w, "Failed to fetch pharmacies", http.StatusInternalServerError)
 		return
 	}
 
+	// Пользователь видит только закреплённые за ним аптеки
+	visible := make([]models.Pharmacy, 0, len(pharmacies))
+	for _, p := range pharmacies {
+		if scope.Allows(p.ID_CONTRACTOR_GLOBAL) {
+			visible = append(visible, p)
+		}
+	}
+	pharmacies = visible
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(pharmacies)
 }
@@ -15,10 +15,11 @@ import (
 
 type ProductHandler struct {
 	service *services.ProductService
+	access  *services.AccessService
 }
 
-func NewProductHandler(service *services.ProductService) *ProductHandler {
-	return &ProductHandler{service: service}
+func NewProductHandler(service *services.ProductService, access *services.AccessService) *ProductHandler {
+	return &ProductHandler{service: service, access: access}
 }
 
 // GetInactiveStockProducts godoc
@@ -65,6 +66,10 @@ func (h *ProductHandler) GetInactiveStockProducts(w http.ResponseWriter, r *http
 		return
 	}
 
+	if !requireContractorAccess(w, r, h.access, contractorID) {
+		return
+	}
+
 	var namePtr *string
 	if nameFilter != "" {
 		namePtr = &nameFilter
@@ -102,6 +107,10 @@ func (h *ProductHandler) GetProductStockWithSalesSpeed(w http.ResponseWriter, r
 		return
 	}
 
+	if !requireContractorAccess(w, r, h.access, contractorID) {
+		return
+	}
+
 	products, err := h.service.GetProductStockWithSalesSpeed(contractorID, days, goodsIDStr, speedOrRout)
 	if err != nil {
 		http.Error(w, "failed to fetch product stock", http.StatusInternalServerError)
@@ -141,6 +150,10 @@ func (h *ProductHandler) ExportInactiveStockProductsExcel(w http.ResponseWriter,
 		http.Error(w, "missing contractor_id", http.StatusBadRequest)
 		return
 	}
+
+	if !requireContractorAccess(w, r, h.access, contractorID) {
+		return
+	}
 	limit := 1000
 	if s, err := strconv.Atoi(limitStr); err == nil && s > 0 {
 		limit = s
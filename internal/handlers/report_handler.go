@@ -24,14 +24,22 @@ var reportTemplate string
 
 type ReportHandler struct {
 	reportService *services.ReportService
+	offerService  *services.OfferService
+	access        *services.AccessService
 	chromeCtx     context.Context // контекст для chromedp (можно кэшировать)
 }
 
-func NewReportHandler(reportService *services.ReportService) *ReportHandler {
+func NewReportHandler(
+	reportService *services.ReportService,
+	offerService *services.OfferService,
+	access *services.AccessService,
+) *ReportHandler {
 	// Создаём общий контекст для chromedp (можно переиспользовать)
 	ctx, _ := chromedp.NewContext(context.Background())
 	return &ReportHandler{
 		reportService: reportService,
+		offerService:  offerService,
+		access:        access,
 		chromeCtx:     ctx,
 	}
 }
@@ -49,6 +57,15 @@ func (h *ReportHandler) GenerateOfferReport(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	offer, err := h.offerService.GetOffer(r.Context(), offerID)
+	if err != nil {
+		writeOfferLookupError(w, err)
+		return
+	}
+	if !requireContractorAccess(w, r, h.access, offer.IdContractorGlobalFrom) {
+		return
+	}
+
 	report, err := h.reportService.BuildReport(r.Context(), offerID)
 	if err != nil {
 		http.Error(w, "failed to build report: "+err.Error(), http.StatusInternalServerError)
@@ -4,18 +4,29 @@ import (
 	"encoding/json"
 	"net/http"
 
-	_ "RemainsManager/internal/models"
+	"RemainsManager/internal/middleware"
+	"RemainsManager/internal/models"
 	"RemainsManager/internal/services"
 )
 
 type UserHandler struct {
 	service *services.UserService
+	access  *services.AccessService
 }
 
-func NewUserHandler(service *services.UserService) *UserHandler {
-	return &UserHandler{service: service}
+func NewUserHandler(service *services.UserService, access *services.AccessService) *UserHandler {
+	return &UserHandler{service: service, access: access}
 }
 
+// GetAllUsers godoc
+// @Summary		Пользователи ERP
+// @Description	Возвращает всех пользователей meta_user
+// @Tags			admin
+// @Produce		json
+// @Success		200	{array}	models.User
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/admin/users [get]
 func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 	users, err := h.service.GetAllUsers()
 	if err != nil {
@@ -26,3 +37,90 @@ func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(users)
 }
+
+// GetMe godoc
+// @Summary		Текущий пользователь
+// @Description	Возвращает профиль, роли, разрешения, закреплённые аптеки и настройки
+// @Tags			me
+// @Produce		json
+// @Success		200	{object}	models.Me
+// @Failure		401	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/me [get]
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	me, err := h.service.GetMe(r.Context(), claims)
+	if err != nil {
+		http.Error(w, "Failed to fetch profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(me)
+}
+
+// UpdatePreferences godoc
+// @Summary		Сохранить настройки
+// @Description	Заменяет настройки текущего пользователя; нулевые значения сбрасываются к умолчаниям
+// @Tags			me
+// @Accept			json
+// @Produce		json
+// @Param			body	body		models.UserPreferences	true	"Настройки"
+// @Success		200	{object}	map[string]string
+// @Failure		400	{object}	map[string]string
+// @Failure		403	{object}	map[string]string
+// @Failure		500	{object}	map[string]string
+// @Security		ApiKeyAuth
+// @Router			/me/preferences [put]
+func (h *UserHandler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var prefs models.UserPreferences
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if prefs.DefaultDays < 0 || prefs.DefaultDays > 3650 {
+		http.Error(w, "default_days must be between 1 and 3650", http.StatusBadRequest)
+		return
+	}
+	if prefs.PageSize < 0 || prefs.PageSize > 1000 {
+		http.Error(w, "page_size must be between 1 and 1000", http.StatusBadRequest)
+		return
+	}
+	if prefs.DefaultDays == 0 {
+		prefs.DefaultDays = models.DefaultPreferenceDays
+	}
+	if prefs.PageSize == 0 {
+		prefs.PageSize = models.DefaultPreferencePageSize
+	}
+
+	if prefs.DefaultContractor != "" {
+		if !isGUID(prefs.DefaultContractor) {
+			http.Error(w, "invalid default_contractor", http.StatusBadRequest)
+			return
+		}
+		if !requireContractorAccess(w, r, h.access, prefs.DefaultContractor) {
+			return
+		}
+	}
+
+	if err := h.service.SavePreferences(r.Context(), claims.Subject, prefs); err != nil {
+		http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"RemainsManager/internal/models"
+	"RemainsManager/internal/repositories"
+
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+)
+
+// NewAuditMiddleware записывает в журнал аудита изменяющие вызовы (все методы, кроме GET, HEAD и OPTIONS):
+// пользователя, IP, шаблон маршрута, ID сущности из пути и HTTP-статус ответа.
+// Подключается после NewAuthMiddleware, чтобы пользователь был известен.
+func NewAuditMiddleware(audit repositories.AuditWriter) func(http.Handler) http.Handler {
+	return newAuditMiddleware(audit, false)
+}
+
+// NewRouteAuditMiddleware записывает в журнал аудита каждый вызов маршрута, включая GET.
+// Подключается через With к GET-маршрутам, которые меняют данные (GET /offer создаёт заявку).
+func NewRouteAuditMiddleware(audit repositories.AuditWriter) func(http.Handler) http.Handler {
+	return newAuditMiddleware(audit, true)
+}
+
+func newAuditMiddleware(audit repositories.AuditWriter, allMethods bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				if !allMethods {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			ww := chimw.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			entry := &models.AuditEntry{
+				IP:      ClientIP(r),
+				Action:  models.AuditActionRequest,
+				Method:  r.Method,
+				Route:   r.URL.Path,
+				Status:  status,
+				Outcome: models.AuditOutcome(status),
+			}
+			if claims, ok := UserFromContext(r.Context()); ok {
+				entry.User = claims.Subject
+			}
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				if pattern := rctx.RoutePattern(); pattern != "" {
+					entry.Route = pattern
+				}
+				entry.EntityID = entityID(rctx)
+			}
+
+			if err := audit.WriteAudit(context.WithoutCancel(r.Context()), entry); err != nil {
+				log.Printf("Failed to write audit entry: %v", err)
+			}
+		})
+	}
+}
+
+// ClientIP возвращает адрес клиента из RemoteAddr без порта
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// entityID собирает значения параметров пути ({id}, {name}) через запятую
+func entityID(rctx *chi.Context) string {
+	var values []string
+	for i, key := range rctx.URLParams.Keys {
+		if key == "*" || i >= len(rctx.URLParams.Values) {
+			continue
+		}
+		values = append(values, rctx.URLParams.Values[i])
+	}
+	return strings.Join(values, ",")
+}
@@ -1,34 +1,123 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
+	"RemainsManager/config"
+	"RemainsManager/internal/models"
+	"RemainsManager/internal/repositories"
+
 	"github.com/dgrijalva/jwt-go"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "missing token", http.StatusUnauthorized)
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+// APIKeyVerifier проверяет API-ключ сервисной учётной записи.
+// Для неизвестного или отозванного ключа возвращает nil без ошибки.
+type APIKeyVerifier interface {
+	VerifyAPIKey(ctx context.Context, key string) (*models.UserClaims, error)
+}
+
+// NewAuthMiddleware проверяет токен доступа или API-ключ и сохраняет данные пользователя в контексте.
+// Ключ проверки токена выбирается по заголовку kid из security; токены из списка отозванных отклоняются.
+// API-ключ передаётся в заголовке X-API-Key или как "Authorization: ApiKey <ключ>".
+func NewAuthMiddleware(
+	security config.SecurityConfig,
+	denylist repositories.TokenDenylist,
+	apiKeys APIKeyVerifier,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var claims *models.UserClaims
+			authHeader := r.Header.Get("Authorization")
+
+			switch {
+			case r.Header.Get("X-API-Key") != "" || strings.HasPrefix(authHeader, "ApiKey "):
+				key := r.Header.Get("X-API-Key")
+				if key == "" {
+					key = strings.TrimPrefix(authHeader, "ApiKey ")
+				}
+
+				var err error
+				claims, err = apiKeys.VerifyAPIKey(r.Context(), key)
+				if err != nil {
+					log.Printf("Failed to verify api key: %v", err)
+					http.Error(w, "failed to verify api key", http.StatusInternalServerError)
+					return
+				}
+				if claims == nil {
+					http.Error(w, "invalid api key", http.StatusUnauthorized)
+					return
+				}
+
+			case authHeader != "":
+				tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+				claims = &models.UserClaims{}
+				token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+					if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+						return nil, fmt.Errorf("unexpected signing method")
+					}
+					kid, _ := token.Header["kid"].(string)
+					secret, ok := security.VerificationKey(kid)
+					if !ok {
+						return nil, fmt.Errorf("unknown signing key %q", kid)
+					}
+					return []byte(secret), nil
+				})
+
+				if err != nil || !token.Valid || claims.Type != models.TokenTypeAccess {
+					http.Error(w, "invalid token", http.StatusUnauthorized)
+					return
+				}
+
+				revoked, err := denylist.IsRevoked(r.Context(), claims.Id)
+				if err != nil {
+					log.Printf("Failed to check token revocation: %v", err)
+					http.Error(w, "failed to verify token", http.StatusInternalServerError)
+					return
+				}
+				if revoked {
+					http.Error(w, "token revoked", http.StatusUnauthorized)
+					return
+				}
+
+			default:
+				http.Error(w, "missing token", http.StatusUnauthorized)
+				return
 			}
-			return []byte("supersecretkey"), nil
+
+			ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
+	}
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
-			return
-		}
+// RequirePermission пропускает запрос, только если роли пользователя дают разрешение
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := UserFromContext(r.Context())
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !claims.HasPermission(permission) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-		next.ServeHTTP(w, r)
-	})
+// UserFromContext возвращает данные пользователя, сохранённые AuthMiddleware
+func UserFromContext(ctx context.Context) (*models.UserClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*models.UserClaims)
+	return claims, ok
 }
@@ -15,7 +15,7 @@ func EnableCORS(next http.Handler) http.Handler {
 
 		// Разрешённые методы и заголовки
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, X-API-Key")
 
 		// Обработка preflight-запросов
 		if r.Method == "OPTIONS" {
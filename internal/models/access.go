@@ -0,0 +1,32 @@
+package models
+
+import "strings"
+
+// ContractorScope — аптеки, с которыми может работать пользователь
+type ContractorScope struct {
+	All           bool     `json:"all"`            // доступ ко всей сети
+	ContractorIDs []string `json:"contractor_ids"` // закреплённые аптеки (ID_CONTRACTOR_GLOBAL)
+}
+
+// Allows проверяет доступ к контрагенту (GUID сравниваются без учёта регистра)
+func (s *ContractorScope) Allows(contractorID string) bool {
+	if s.All {
+		return true
+	}
+	for _, id := range s.ContractorIDs {
+		if strings.EqualFold(id, contractorID) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserContractorsRequest — список аптек для закрепления за пользователем
+type UserContractorsRequest struct {
+	ContractorIDs []string `json:"contractor_ids"`
+}
+
+// UserRolesRequest — список ролей пользователя
+type UserRolesRequest struct {
+	Roles []string `json:"roles"`
+}
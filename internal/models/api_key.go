@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// APIKeySubjectPrefix отличает сервисные учётные записи от пользователей meta_user
+const APIKeySubjectPrefix = "apikey:"
+
+// APIKeyMaxNameLength — длина имени ключа, при которой "apikey:<имя>" помещается в столбцы USER_NAME (100)
+const APIKeyMaxNameLength = 100 - len(APIKeySubjectPrefix)
+
+// APIKey — API-ключ сервисной учётной записи (без самого ключа)
+type APIKey struct {
+	ID            int64      `json:"id"`
+	Name          string     `json:"name"`
+	Prefix        string     `json:"prefix"`         // первые символы ключа для опознания
+	Scopes        []string   `json:"scopes"`         // разрешения
+	ContractorIDs []string   `json:"contractor_ids"` // закреплённые аптеки; без pharmacies:all ключ видит только их
+	CreatedBy     string     `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastUsedAt    *time.Time `json:"last_used_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+}
+
+// CreateAPIKeyRequest — параметры нового ключа.
+// Ключ без разрешения pharmacies:all должен быть закреплён хотя бы за одной аптекой.
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ContractorIDs []string `json:"contractor_ids"`
+}
+
+// CreatedAPIKey возвращается один раз при создании: ключ в открытом виде больше не доступен
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
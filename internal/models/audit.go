@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Действия журнала аудита
+const (
+	AuditActionLogin       = "login"
+	AuditActionLoginFailed = "login_failed"
+	AuditActionRequest     = "request"
+)
+
+// Результаты действий журнала аудита
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeDenied  = "denied"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditEntry — запись журнала аудита
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	User      string    `json:"user"`
+	IP        string    `json:"ip"`
+	Action    string    `json:"action"`
+	Method    string    `json:"method,omitempty"`
+	Route     string    `json:"route,omitempty"`
+	EntityID  string    `json:"entity_id,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Details   string    `json:"details,omitempty"`
+}
+
+// AuditFilter — условия выборки журнала аудита
+type AuditFilter struct {
+	From   time.Time
+	To     time.Time
+	User   string
+	Action string
+	Limit  int
+}
+
+// AuditOutcome определяет результат запроса по HTTP-статусу
+func AuditOutcome(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return AuditOutcomeDenied
+	case status >= 400:
+		return AuditOutcomeFailure
+	default:
+		return AuditOutcomeSuccess
+	}
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/xml"
+	"fmt"
 	"time"
 )
 
@@ -41,6 +42,9 @@ type InterfirmRow struct {
 	IsWeight                    int     `json:"is_weight"`
 	Kiz                         *string `json:"kiz"`    // всегда NULL (по твоему SQL)
 	IsKiz                       int     `json:"is_kiz"` // 1 или 0
+
+	// Причины, по которым строку нельзя провести; пусто — строка корректна
+	Problems []string `json:"problems,omitempty"`
 }
 
 type InterfirmItemXML struct {
@@ -88,3 +92,87 @@ type InterfirmXML struct {
 	XMLName xml.Name           `xml:"XML"`
 	Moving  InterfirmMovingXML `xml:"INTERFIRM_MOVING"`
 }
+
+// InterfirmMovingDoc — готовый XML межфирменного перемещения для одного получателя
+type InterfirmMovingDoc struct {
+	ContractorTo string // ID_CONTRACTOR_GLOBAL получателя
+	MovingGlobal string // ID_INTERFIRM_MOVING_GLOBAL документа
+	ItemCount    int
+	XML          string
+}
+
+// OfferMoving — межфирменное перемещение, созданное по заявке
+type OfferMoving struct {
+	OfferID        int64     `json:"offer_id"`
+	ContractorTo   string    `json:"id_contractor_global_to"`
+	ContractorName string    `json:"contractor_to"`
+	MovingGlobal   string    `json:"id_interfirm_moving_global"`
+	Mnemocode      string    `json:"mnemocode,omitempty"` // номер документа в ERP
+	ItemCount      int       `json:"item_count"`
+	CreatedBy      string    `json:"created_by"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// OfferDocument — XML перемещения, сформированный при попытке обработки заявки.
+// Saved — документ принят ERP и связан с заявкой в OFFER_MOVING.
+type OfferDocument struct {
+	ID             int64     `json:"id"`
+	OfferID        int64     `json:"offer_id"`
+	ContractorTo   string    `json:"id_contractor_global_to"`
+	ContractorName string    `json:"contractor_to"`
+	MovingGlobal   string    `json:"id_interfirm_moving_global"`
+	ItemCount      int       `json:"item_count"`
+	Saved          bool      `json:"saved"`
+	CreatedBy      string    `json:"created_by"`
+	CreatedAt      time.Time `json:"created_at"`
+	XML            string    `json:"-"`
+}
+
+// Итог обработки заявки по получателю (OFFER_RECEIVER_OUTCOME.STATUS)
+const (
+	ReceiverOutcomeSaved  = "saved"
+	ReceiverOutcomeFailed = "failed"
+)
+
+// ReceiverOutcome — итог последней попытки обработки заявки по получателю
+type ReceiverOutcome struct {
+	OfferID        int64     `json:"offer_id"`
+	ContractorTo   string    `json:"id_contractor_global_to"`
+	ContractorName string    `json:"contractor_to"`
+	Status         string    `json:"status"`
+	MovingGlobal   string    `json:"id_interfirm_moving_global,omitempty"`
+	ItemCount      int       `json:"item_count"`
+	Attempts       int       `json:"attempts"`
+	Error          string    `json:"error,omitempty"`
+	User           string    `json:"user"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// OfferProcessingError — часть получателей не удалось сохранить; сохранённые остаются,
+// заявка переводится в статус "ошибка", повторная обработка берёт только неудачных
+type OfferProcessingError struct {
+	OfferID int64
+	Saved   int
+	Failed  []ReceiverOutcome // заполнены ContractorTo и Error
+}
+
+func (e *OfferProcessingError) Error() string {
+	if len(e.Failed) == 0 {
+		return fmt.Sprintf("offer %d: processing failed", e.OfferID)
+	}
+	f := e.Failed[0]
+	if len(e.Failed) == 1 {
+		return fmt.Sprintf("offer %d: moving for %s failed: %s", e.OfferID, f.ContractorTo, f.Error)
+	}
+	return fmt.Sprintf("offer %d: %d receivers failed, %d saved; e.g. %s: %s",
+		e.OfferID, len(e.Failed), e.Saved, f.ContractorTo, f.Error)
+}
+
+// OfferProcessResult — результат обработки заявки.
+// AlreadyProcessed — заявка была отработана раньше, возвращены ранее созданные документы.
+type OfferProcessResult struct {
+	OfferID          int64         `json:"offer_id"`
+	Status           string        `json:"status"`
+	AlreadyProcessed bool          `json:"already_processed"`
+	Movings          []OfferMoving `json:"movings"`
+}
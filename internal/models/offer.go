@@ -30,22 +30,117 @@ type Offer struct {
 	Name                   string      `json:"name"`
 	IdContractorGlobalFrom string      `json:"id_contractor_global_from"`
 	CreatedAt              time.Time   `json:"created_at"`
-	Status                 int         `json:"status"` // статус
+	Status                 int         `json:"status"`     // статус
+	IsDefault              bool        `json:"is_default"` // заявка "на сегодня", её возвращает GET /offer
 	OfferItems             []OfferItem `json:"items"`
 }
 
+// OfferDraft — открытая (редактируемая) заявка отправителя в списке черновиков
+type OfferDraft struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Status    int       `json:"status"`
+	IsDefault bool      `json:"is_default"`
+	ItemCount int       `json:"item_count"`
+	Quantity  int       `json:"quantity"` // сумма количеств по позициям
+}
+
+// CreateDraftRequest — новый именованный черновик заявки
+type CreateDraftRequest struct {
+	FromID string `json:"from_id"`
+	Name   string `json:"name"`
+}
+
 type OfferJournalItem struct {
 	ID         int64  `json:"id"`
 	Mnemocode  string `json:"mnemocode"`  // имя заявки
 	Contractor string `json:"contractor"` // контрагент-отправитель
 	CreatedAt  string `json:"created"`    // дата создания (YYYY-MM-DD)
 	Status     int    `json:"status"`     // статус
+
+	// Размер заявки
+	ItemCount     int     `json:"item_count"`
+	ReceiverCount int     `json:"receiver_count"`
+	TotalQuantity int     `json:"total_quantity"`
+	TotalRetail   float64 `json:"total_retail"` // сумма в розничных ценах партий (LOT.PRICE_SAL)
+
+	// Последняя смена статуса; пусто, если статус не менялся
+	LastChangedAt *time.Time `json:"last_changed_at,omitempty"`
+	LastChangedBy string     `json:"last_changed_by,omitempty"`
+	LastComment   string     `json:"last_comment,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// Сортировка журнала заявок
+const (
+	JournalSortDate   = "date"
+	JournalSortSender = "sender"
+	JournalSortTotal  = "total"
+)
+
+// OfferJournalFilter — отбор, сортировка и страница журнала заявок
+type OfferJournalFilter struct {
+	From       time.Time
+	To         time.Time
+	Contractor string   // отправитель; пусто — все
+	Allowed    []string // nil — без ограничения, иначе только эти отправители
+	Statuses   []int    // пусто — все статусы
+	Receiver   string   // заявки, где есть позиции этому получателю
+	Search     string   // часть названия заявки
+	Sort       string   // JournalSort*
+	Desc       bool
+	Page       int
+	Limit      int
+}
+
+// OfferStatusChange — запись истории статусов заявки
+type OfferStatusChange struct {
+	ID        int64     `json:"id"`
+	OfferID   int64     `json:"offer_id"`
+	From      int       `json:"status_from"`
+	To        int       `json:"status_to"`
+	User      string    `json:"user"`
+	ChangedAt time.Time `json:"changed_at"`
+	Comment   string    `json:"comment,omitempty"`
+	Error     string    `json:"error,omitempty"` // текст ошибки при переходе в статус "ошибка"
+}
+
+// OfferStatusRequest — необязательный комментарий к смене статуса
+type OfferStatusRequest struct {
+	Comment string `json:"comment"`
 }
 
 // OfferDetailItem — детализация одной позиции заявки
 type OfferDetailItem struct {
-	GoodsName    string `json:"goods_name"`    // "Название | Производитель"
-	ContractorTo string `json:"contractor_to"` // контрагент-получатель
-	Quantity     int    `json:"quantity"`
-	ID           int    `json:"id_item"`
+	GoodsName      string `json:"goods_name"`    // "Название | Производитель"
+	ContractorTo   string `json:"contractor_to"` // контрагент-получатель
+	ContractorToID string `json:"id_contractor_global_to"`
+	Quantity       int    `json:"quantity"`
+	ID             int    `json:"id_item"`
+
+	// Партия отправителя
+	LotGlobal       string  `json:"id_lot_global"`
+	LotName         string  `json:"lot_name"`
+	InternalBarcode string  `json:"internal_barcode"`
+	PriceSal        float64 `json:"price_sal"`
+	PriceProd       float64 `json:"price_prod"`
+	LineValue       float64 `json:"line_value"` // Quantity × PriceSal
+	BestBefore      string  `json:"best_before,omitempty"`
+	SenderRemains   float64 `json:"sender_remains"` // текущий остаток партии
+
+	// Получатель: остаток товара по всем его партиям и продажи за период
+	ReceiverStock       float64 `json:"receiver_stock"`
+	ReceiverSold        float64 `json:"receiver_sold"`
+	ReceiverSalesPerDay float64 `json:"receiver_sales_per_day"`
+}
+
+// OfferReceiverDetails — позиции заявки одному получателю (детали с group_by=receiver)
+type OfferReceiverDetails struct {
+	ContractorToID string            `json:"id_contractor_global_to"`
+	ContractorTo   string            `json:"contractor_to"`
+	ItemCount      int               `json:"item_count"`
+	TotalQuantity  int               `json:"total_quantity"`
+	TotalValue     float64           `json:"total_value"`
+	Items          []OfferDetailItem `json:"items"`
 }
@@ -0,0 +1,85 @@
+package models
+
+import "fmt"
+
+// Действия над позицией при массовой правке заявки
+const (
+	BulkActionDelete = "delete"
+	BulkActionMove   = "move"
+	BulkActionUpdate = "update"
+)
+
+// Результат массовой правки по позиции
+const (
+	BulkItemApplied    = "applied"
+	BulkItemMerged     = "merged" // перенесена к получателю, у которого уже есть строка той же партии
+	BulkItemUnchanged  = "unchanged"
+	BulkItemFailed     = "failed"
+	BulkItemRolledBack = "rolled_back" // позиция в порядке, но правка отменена из-за других позиций
+)
+
+// OfferItemPatch — правка одной позиции заявки.
+// Delete удаляет позицию; иначе меняются заданные поля: количество и/или получатель.
+type OfferItemPatch struct {
+	ItemID       int64   `json:"id_item"`
+	Quantity     *int    `json:"quantity,omitempty"`
+	ContractorTo *string `json:"id_contractor_global_to,omitempty"`
+	Delete       bool    `json:"delete,omitempty"`
+	FromReceiver string  `json:"-"` // если задан, позиция должна принадлежать этому получателю
+}
+
+// MoveItemsRequest — перенос позиций другому получателю: перечисленных или всех позиций FromReceiver.
+// Если заданы оба, перечисленные позиции должны принадлежать FromReceiver.
+type MoveItemsRequest struct {
+	ItemIDs      []int64 `json:"item_ids"`
+	FromReceiver string  `json:"from_id"`
+	ToReceiver   string  `json:"to_id"`
+}
+
+// ScaleItemsRequest — пересчёт количеств в процентах от текущего (50 — вдвое меньше, 150 — в полтора раза больше).
+// Результат округляется и не бывает меньше 1; ReceiverID ограничивает пересчёт одним получателем.
+type ScaleItemsRequest struct {
+	Percent    float64 `json:"percent"`
+	ReceiverID string  `json:"receiver_id,omitempty"`
+}
+
+// PatchItemsRequest — список правок позиций заявки
+type PatchItemsRequest struct {
+	Patches []OfferItemPatch `json:"patches"`
+}
+
+// OfferItemResult — итог массовой правки по одной позиции
+type OfferItemResult struct {
+	ItemID         int64  `json:"id_item"`
+	Action         string `json:"action"`
+	Status         string `json:"status"`
+	ContractorTo   string `json:"id_contractor_global_to,omitempty"` // получатель после правки
+	QuantityBefore int    `json:"quantity_before"`
+	QuantityAfter  int    `json:"quantity_after"`
+	MergedInto     int64  `json:"merged_into,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// OfferBulkResult — итог массовой правки, применённой целиком
+type OfferBulkResult struct {
+	OfferID int64             `json:"offer_id"`
+	Items   []OfferItemResult `json:"items"`
+}
+
+// OfferBulkEditError — массовая правка отменена целиком: часть позиций не прошла проверку (HTTP 422)
+// или партиям не хватает остатка с учётом резервов (HTTP 409, заполнено Lots)
+type OfferBulkEditError struct {
+	OfferID int64
+	Items   []OfferItemResult
+	Lots    []LotAllocation
+}
+
+func (e *OfferBulkEditError) Error() string {
+	failed := 0
+	for _, item := range e.Items {
+		if item.Status == BulkItemFailed {
+			failed++
+		}
+	}
+	return fmt.Sprintf("bulk edit of offer %d rolled back: %d items failed", e.OfferID, failed)
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// Состояния фонового задания обработки заявки
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// Этапы обработки заявки
+const (
+	JobStageValidate = "validate" // проверка складов и партий
+	JobStageGenerate = "generate" // usp_GenerateInterfirmMovingFromOffer и XML
+	JobStageSave     = "save"     // USP_INTERFIRM_MOVING_SAVE по получателям
+	JobStageDone     = "done"
+)
+
+// Состояния перемещения одному получателю внутри задания
+const (
+	ReceiverPending = "pending"
+	ReceiverSaved   = "saved"
+	ReceiverFailed  = "failed"
+)
+
+// OfferJob — фоновое задание обработки заявки и его ход
+type OfferJob struct {
+	ID         string              `json:"id"`
+	OfferID    int64               `json:"offer_id"`
+	Retry      bool                `json:"retry"` // повтор только неудачных получателей
+	User       string              `json:"user"`
+	Status     string              `json:"status"`
+	Stage      string              `json:"stage,omitempty"`
+	Receivers  []ReceiverProgress  `json:"receivers"`
+	Error      string              `json:"error,omitempty"`
+	Problems   []OfferItemProblem  `json:"problems,omitempty"` // заявка не прошла проверку складов и партий
+	Result     *OfferProcessResult `json:"result,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	StartedAt  *time.Time          `json:"started_at,omitempty"`
+	FinishedAt *time.Time          `json:"finished_at,omitempty"`
+}
+
+// ReceiverProgress — перемещение одному получателю в задании
+type ReceiverProgress struct {
+	ContractorTo string `json:"id_contractor_global_to"`
+	MovingGlobal string `json:"id_interfirm_moving_global"`
+	ItemCount    int    `json:"item_count"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Finished — задание завершено успешно или с ошибкой
+func (j *OfferJob) Finished() bool {
+	return j.Status == JobStatusSucceeded || j.Status == JobStatusFailed
+}
@@ -0,0 +1,73 @@
+package models
+
+import "fmt"
+
+// OfferPreview — пробная обработка заявки: что будет создано в ERP, без сохранения
+type OfferPreview struct {
+	OfferID      int64                 `json:"offer_id"`
+	Status       int                   `json:"status"`
+	CanProcess   bool                  `json:"can_process"`   // статус допускает обработку и проблем нет
+	ItemProblems []OfferItemProblem    `json:"item_problems"` // предварительная проверка складов и партий
+	Receivers    []MovingPreview       `json:"receivers"`
+	FailedRows   []InterfirmRowProblem `json:"failed_rows"`
+}
+
+// MovingPreview — будущее перемещение одному получателю.
+// GUID документа и позиций генерируются заново при каждом вызове процедуры.
+type MovingPreview struct {
+	ContractorTo     string              `json:"id_contractor_global_to"`
+	MovingGlobal     string              `json:"id_interfirm_moving_global"`
+	StoreFromMain    int64               `json:"id_store_from_main"`
+	StoreFromTransit int64               `json:"id_store_from_transit"` // TRS отправителя
+	StoreToMain      int64               `json:"id_store_to_main"`
+	StoreToTransit   int64               `json:"id_store_to_transit"` // TRR получателя
+	ItemCount        int                 `json:"item_count"`
+	Quantity         float64             `json:"quantity"`
+	SumSupplier      float64             `json:"sum_supplier"`
+	SVatSupplier     float64             `json:"svat_supplier"`
+	SumRetail        float64             `json:"sum_retail"`
+	SVatRetail       float64             `json:"svat_retail"`
+	Items            []MovingItemPreview `json:"items"`
+	Valid            bool                `json:"valid"`
+	XML              string              `json:"xml,omitempty"` // только для получателей без проблемных строк
+}
+
+// MovingItemPreview — позиция будущего перемещения
+type MovingItemPreview struct {
+	IDLotFrom    int64   `json:"id_lot_from"`
+	Quantity     float64 `json:"quantity"`
+	SumSupplier  float64 `json:"sum_supplier"`
+	SVatSupplier float64 `json:"svat_supplier"`
+	PriceRetail  float64 `json:"price_retail"`
+	VatRetail    float64 `json:"vat_retail"`
+	IsKiz        bool    `json:"is_kiz"`
+}
+
+// InterfirmRowProblem — строка заявки, которую нельзя провести
+type InterfirmRowProblem struct {
+	ContractorTo string   `json:"id_contractor_global_to"`
+	IDLotFrom    int64    `json:"id_lot_from"`
+	Quantity     float64  `json:"quantity"`
+	Problems     []string `json:"problems"`
+}
+
+// OfferItemProblem — позиция заявки, которая не пройдёт обработку, с причинами
+type OfferItemProblem struct {
+	ItemID         int64    `json:"id_item"`
+	GoodsName      string   `json:"goods_name"`
+	ContractorFrom string   `json:"id_contractor_global_from"`
+	ContractorTo   string   `json:"id_contractor_global_to"`
+	LotGlobal      string   `json:"id_lot_global"`
+	Quantity       int      `json:"quantity"`
+	Problems       []string `json:"problems"`
+}
+
+// OfferValidationError — заявка не прошла предварительную проверку складов и партий (HTTP 422)
+type OfferValidationError struct {
+	OfferID  int64
+	Problems []OfferItemProblem
+}
+
+func (e *OfferValidationError) Error() string {
+	return fmt.Sprintf("offer %d has %d items that cannot be processed", e.OfferID, len(e.Problems))
+}
@@ -0,0 +1,66 @@
+package models
+
+import "fmt"
+
+// offerTransitions — разрешённые переходы статусов заявки.
+// Отработанная и удалённая заявки конечны; из ошибки можно повторить обработку.
+var offerTransitions = map[int][]int{
+	OfferStatusNew:   {OfferStatusSent, OfferStatusProcessed, OfferStatusError, OfferStatusDeleted},
+	OfferStatusSent:  {OfferStatusProcessed, OfferStatusError, OfferStatusDeleted},
+	OfferStatusError: {OfferStatusProcessed, OfferStatusError, OfferStatusDeleted},
+}
+
+var offerStatusNames = map[int]string{
+	OfferStatusNew:       "new",
+	OfferStatusSent:      "sent",
+	OfferStatusProcessed: "processed",
+	OfferStatusError:     "error",
+	OfferStatusDeleted:   "deleted",
+}
+
+// CanTransitionOffer сообщает, разрешён ли переход заявки из статуса from в статус to
+func CanTransitionOffer(from, to int) bool {
+	for _, s := range offerTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OfferItemsEditable сообщает, можно ли менять позиции заявки в этом статусе.
+// Позиции отработанной заявки уже вошли в перемещения, удалённой — не нужны.
+// В заявке с ошибкой не меняются позиции получателей, чьё перемещение уже сохранено (ReceiverSavedError).
+func OfferItemsEditable(status int) bool {
+	return status == OfferStatusNew || status == OfferStatusSent || status == OfferStatusError
+}
+
+// OfferStatusName возвращает имя статуса для сообщений об ошибках
+func OfferStatusName(status int) string {
+	if name, ok := offerStatusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", status)
+}
+
+// OfferStateError — действие недопустимо в текущем статусе заявки (HTTP 409)
+type OfferStateError struct {
+	OfferID int64
+	Status  int    // текущий статус
+	Action  string // send, process, delete, edit items
+}
+
+func (e *OfferStateError) Error() string {
+	return fmt.Sprintf("offer %d is %s: cannot %s", e.OfferID, OfferStatusName(e.Status), e.Action)
+}
+
+// ReceiverSavedError — перемещение получателю по заявке уже сохранено в ERP,
+// поэтому его позиции менять нельзя, даже если заявка ещё не отработана (HTTP 409)
+type ReceiverSavedError struct {
+	OfferID      int64
+	ContractorTo string
+}
+
+func (e *ReceiverSavedError) Error() string {
+	return fmt.Sprintf("offer %d: interfirm moving for receiver %s is already saved: cannot edit its items", e.OfferID, e.ContractorTo)
+}
@@ -20,6 +20,8 @@ type InactiveStockProduct struct {
 	IdGoodsGlobal   string  `json:"id_goods_global"`
 	NoMovement      bool    `json:"no_movement"`
 	InternalBarcode string  `json:"internal_barcode"`
+	QtyReserved     float64 `json:"qty_reserved"`  // занято позициями незавершённых заявок
+	QtyAvailable    float64 `json:"qty_available"` // Qty за вычетом резерва
 }
 
 type ProductStockWithSalesSpeed struct {
@@ -10,3 +10,8 @@ type AuthRequest struct {
 	// Example: password123
 	Password string `json:"password"`
 }
+
+// RefreshRequest содержит refresh-токен для обновления или отзыва
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
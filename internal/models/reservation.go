@@ -0,0 +1,27 @@
+package models
+
+import "fmt"
+
+// LotAllocation — остаток партии и его распределение между заявками.
+// Резервируют остаток позиции заявок в статусах, где они редактируются, кроме позиций получателей
+// с уже сохранённым перемещением: их остаток списан в ERP (см. V_LOT_RESERVATION).
+type LotAllocation struct {
+	LotGlobal        string  `json:"id_lot_global"`
+	Remaining        float64 `json:"quantity_rem"`       // LOT.QUANTITY_REM
+	ReservedByOthers float64 `json:"reserved_by_others"` // позиции других незавершённых заявок
+	Requested        float64 `json:"requested"`          // позиции изменяемой заявки после изменения
+	Available        float64 `json:"available"`          // сколько ещё можно запросить этой заявкой
+}
+
+// OverAllocationError — позиции заявки запрашивают больше, чем осталось в партиях с учётом резервов (HTTP 409)
+type OverAllocationError struct {
+	Lots []LotAllocation
+}
+
+func (e *OverAllocationError) Error() string {
+	if len(e.Lots) == 1 {
+		l := e.Lots[0]
+		return fmt.Sprintf("lot %s: requested %g, available %g", l.LotGlobal, l.Requested, l.Available)
+	}
+	return fmt.Sprintf("requested quantity exceeds available remains for %d lots", len(e.Lots))
+}
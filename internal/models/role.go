@@ -0,0 +1,83 @@
+package models
+
+// Роли пользователей
+const (
+	RoleViewer      = "viewer"      // Только просмотр
+	RolePharmacist  = "pharmacist"  // Провизор: формирует и отправляет заявки своей аптеки
+	RoleLogistician = "logistician" // Логист: обрабатывает заявки, ведёт маршруты
+	RoleAdmin       = "admin"       // Администратор
+)
+
+// Разрешения, которые требуют маршруты API
+const (
+	PermissionRead           = "read"                   // Чтение справочников, остатков, заявок
+	PermissionOffersEdit     = "offers:edit"            // Создание заявок и изменение позиций
+	PermissionOffersSend     = "offers:send"            // Отметка заявки как отправленной
+	PermissionOffersDelete   = "offers:delete"          // Удаление заявок
+	PermissionOffersProcess  = "offers:process"         // Создание межфирменных перемещений
+	PermissionAutoDistribute = "offers:auto-distribute" // Автоматическое распределение
+	PermissionRoutesEdit     = "routes:edit"            // Изменение маршрутов
+	PermissionAllPharmacies  = "pharmacies:all"         // Доступ ко всем аптекам сети без закрепления
+	PermissionAdmin          = "admin"                  // Администрирование
+)
+
+// DefaultRole назначается пользователю, для которого нет записей в USER_ROLE
+const DefaultRole = RoleViewer
+
+var rolePermissions = map[string][]string{
+	RoleViewer: {
+		PermissionRead,
+	},
+	RolePharmacist: {
+		PermissionRead,
+		PermissionOffersEdit,
+		PermissionOffersSend,
+	},
+	RoleLogistician: {
+		PermissionRead,
+		PermissionOffersEdit,
+		PermissionOffersSend,
+		PermissionOffersDelete,
+		PermissionOffersProcess,
+		PermissionAutoDistribute,
+		PermissionRoutesEdit,
+		PermissionAllPharmacies,
+	},
+	RoleAdmin: {
+		PermissionRead,
+		PermissionOffersEdit,
+		PermissionOffersSend,
+		PermissionOffersDelete,
+		PermissionOffersProcess,
+		PermissionAutoDistribute,
+		PermissionRoutesEdit,
+		PermissionAllPharmacies,
+		PermissionAdmin,
+	},
+}
+
+// IsValidPermission проверяет, что разрешение известно системе
+func IsValidPermission(permission string) bool {
+	for _, p := range rolePermissions[RoleAdmin] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidRole проверяет, что роль известна системе
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleHasPermission проверяет, входит ли разрешение в роль
+func RoleHasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
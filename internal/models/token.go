@@ -0,0 +1,14 @@
+package models
+
+// Типы токенов
+const (
+	TokenTypeAccess  = "access"  // Токен доступа к /api
+	TokenTypeRefresh = "refresh" // Токен для получения новой пары
+)
+
+// TokenPair — ответ на вход в систему и обновление токенов
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // время жизни токена доступа, секунды
+}
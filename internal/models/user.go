@@ -1,7 +1,80 @@
 package models
 
+import "github.com/dgrijalva/jwt-go"
+
 type User struct {
 	Name     string `json:"name"`
 	FullName string `json:"full_name"`
 	UserNum  int    `json:"user_num"`
 }
+
+// Значения настроек по умолчанию
+const (
+	DefaultPreferenceDays     = 30
+	DefaultPreferencePageSize = 50
+)
+
+// UserPreferences — настройки пользователя, хранящиеся на сервере
+type UserPreferences struct {
+	DefaultDays       int    `json:"default_days"`       // дней без движения по умолчанию
+	DefaultContractor string `json:"default_contractor"` // аптека по умолчанию (ID_CONTRACTOR_GLOBAL)
+	PageSize          int    `json:"page_size"`          // размер страницы
+}
+
+// Me — профиль текущего пользователя
+type Me struct {
+	User          User            `json:"user"`
+	Roles         []string        `json:"roles"`
+	Permissions   []string        `json:"permissions"`
+	AllPharmacies bool            `json:"all_pharmacies"` // доступ ко всей сети
+	Pharmacies    []Pharmacy      `json:"pharmacies"`     // закреплённые аптеки
+	Preferences   UserPreferences `json:"preferences"`
+}
+
+// UserCredentials — пользователь вместе с хешем пароля из meta_user
+type UserCredentials struct {
+	User
+	PasswordHash string
+}
+
+// UserClaims — содержимое токена доступа
+type UserClaims struct {
+	Name     string   `json:"name"`
+	FullName string   `json:"full_name"`
+	UserNum  int      `json:"user_num"`
+	Roles    []string `json:"roles"`
+	Scopes   []string `json:"scopes,omitempty"` // разрешения API-ключа
+	Type     string   `json:"typ"`              // access | refresh
+	jwt.StandardClaims
+}
+
+// User возвращает пользователя, от имени которого выполняется запрос
+func (c *UserClaims) User() User {
+	return User{Name: c.Name, FullName: c.FullName, UserNum: c.UserNum}
+}
+
+// Permissions возвращает все разрешения ролей и scope API-ключа
+func (c *UserClaims) Permissions() []string {
+	var result []string
+	for _, p := range rolePermissions[RoleAdmin] {
+		if c.HasPermission(p) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// HasPermission проверяет, даёт ли разрешение хотя бы одна из ролей или scope API-ключа
+func (c *UserClaims) HasPermission(permission string) bool {
+	for _, scope := range c.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	for _, role := range c.Roles {
+		if RoleHasPermission(role, permission) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AccessRepository управляет ролями пользователей и закреплёнными за ними аптеками
+type AccessRepository struct {
+	db      *sql.DB
+	timeout int
+}
+
+func NewAccessRepository(timeout int, db *sql.DB) *AccessRepository {
+	return &AccessRepository{db: db, timeout: timeout}
+}
+
+// GetUserContractors возвращает ID_CONTRACTOR_GLOBAL аптек пользователя
+func (r *AccessRepository) GetUserContractors(ctx context.Context, username string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT CAST(ID_CONTRACTOR_GLOBAL AS VARCHAR(36))
+		FROM USER_CONTRACTOR
+		WHERE USER_NAME = @username
+	`, sql.Named("username", username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user contractors: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan user contractor: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return ids, nil
+}
+
+// SetUserContractors заменяет список аптек пользователя
+func (r *AccessRepository) SetUserContractors(ctx context.Context, username string, contractorIDs []string) error {
+	return r.replaceUserValues(ctx, "USER_CONTRACTOR", "ID_CONTRACTOR_GLOBAL", username, contractorIDs)
+}
+
+// GetUserRoles возвращает роли пользователя
+func (r *AccessRepository) GetUserRoles(ctx context.Context, username string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT ROLE FROM USER_ROLE
+		WHERE USER_NAME = @username
+		ORDER BY ROLE
+	`, sql.Named("username", username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("failed to scan user role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return roles, nil
+}
+
+// SetUserRoles заменяет роли пользователя
+func (r *AccessRepository) SetUserRoles(ctx context.Context, username string, roles []string) error {
+	return r.replaceUserValues(ctx, "USER_ROLE", "ROLE", username, roles)
+}
+
+// replaceUserValues в одной транзакции удаляет значения пользователя и вставляет новые.
+// table и column — только константы из этого файла.
+func (r *AccessRepository) replaceUserValues(ctx context.Context, table, column, username string, values []string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM "+table+" WHERE USER_NAME = @username",
+		sql.Named("username", username),
+	); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", table, err)
+	}
+
+	for _, v := range values {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO "+table+" (USER_NAME, "+column+") VALUES (@username, @value)",
+			sql.Named("username", username),
+			sql.Named("value", v),
+		); err != nil {
+			return fmt.Errorf("failed to insert into %s value %s: %w", table, v, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"RemainsManager/internal/models"
+)
+
+type APIKeyRepository struct {
+	db      *sql.DB
+	timeout int
+}
+
+func NewAPIKeyRepository(timeout int, db *sql.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db, timeout: timeout}
+}
+
+// CreateAPIKey сохраняет ключ по его хешу вместе с закреплёнными аптеками и возвращает ID
+func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey, keyHash string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO API_KEY (NAME, KEY_PREFIX, KEY_HASH, SCOPES, CREATED_BY)
+		OUTPUT INSERTED.ID_API_KEY
+		VALUES (@name, @prefix, @hash, @scopes, @created_by)
+	`,
+		sql.Named("name", key.Name),
+		sql.Named("prefix", key.Prefix),
+		sql.Named("hash", keyHash),
+		sql.Named("scopes", strings.Join(key.Scopes, ",")),
+		sql.Named("created_by", key.CreatedBy),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	// Ключ с тем же именем мог быть создан и отозван раньше: его аптеки не наследуются
+	subject := models.APIKeySubjectPrefix + key.Name
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM USER_CONTRACTOR WHERE USER_NAME = @username
+	`, sql.Named("username", subject)); err != nil {
+		return 0, fmt.Errorf("failed to clear api key contractors: %w", err)
+	}
+	for _, contractorID := range key.ContractorIDs {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO USER_CONTRACTOR (USER_NAME, ID_CONTRACTOR_GLOBAL) VALUES (@username, @contractor)
+		`, sql.Named("username", subject), sql.Named("contractor", contractorID)); err != nil {
+			return 0, fmt.Errorf("failed to bind api key to contractor %s: %w", contractorID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetAPIKeys возвращает все ключи, включая отозванные
+func (r *APIKeyRepository) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT ID_API_KEY, NAME, KEY_PREFIX, SCOPES, CREATED_BY, CREATED_AT, LAST_USED_AT, REVOKED_AT
+		FROM API_KEY
+		ORDER BY NAME
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	contractors, err := r.apiKeyContractors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		keys[i].ContractorIDs = contractors[keys[i].Name]
+		if keys[i].ContractorIDs == nil {
+			keys[i].ContractorIDs = []string{}
+		}
+	}
+
+	return keys, nil
+}
+
+// apiKeyContractors возвращает аптеки, закреплённые за API-ключами, по имени ключа
+func (r *APIKeyRepository) apiKeyContractors(ctx context.Context) (map[string][]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT USER_NAME, CAST(ID_CONTRACTOR_GLOBAL AS VARCHAR(36))
+		FROM USER_CONTRACTOR
+		WHERE USER_NAME LIKE @prefix + '%'
+		ORDER BY USER_NAME, ID_CONTRACTOR_GLOBAL
+	`, sql.Named("prefix", models.APIKeySubjectPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api key contractors: %w", err)
+	}
+	defer rows.Close()
+
+	contractors := make(map[string][]string)
+	for rows.Next() {
+		var subject, contractorID string
+		if err := rows.Scan(&subject, &contractorID); err != nil {
+			return nil, fmt.Errorf("failed to scan api key contractor: %w", err)
+		}
+		name := strings.TrimPrefix(subject, models.APIKeySubjectPrefix)
+		contractors[name] = append(contractors[name], contractorID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return contractors, nil
+}
+
+// GetActiveAPIKeyByHash ищет неотозванный ключ по хешу; nil, если не найден
+func (r *APIKeyRepository) GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	row := r.db.QueryRowContext(ctx, `
+		SELECT ID_API_KEY, NAME, KEY_PREFIX, SCOPES, CREATED_BY, CREATED_AT, LAST_USED_AT, REVOKED_AT
+		FROM API_KEY
+		WHERE KEY_HASH = @hash AND REVOKED_AT IS NULL
+	`, sql.Named("hash", keyHash))
+
+	key, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// TouchAPIKey отмечает использование ключа не чаще раза в минуту
+func (r *APIKeyRepository) TouchAPIKey(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE API_KEY
+		SET LAST_USED_AT = GETDATE()
+		WHERE ID_API_KEY = @id
+		  AND (LAST_USED_AT IS NULL OR LAST_USED_AT < DATEADD(MINUTE, -1, GETDATE()))
+	`, sql.Named("id", id))
+	if err != nil {
+		return fmt.Errorf("failed to update api key usage: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeAPIKey отзывает ключ по ID
+func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE API_KEY
+		SET REVOKED_AT = GETDATE()
+		WHERE ID_API_KEY = @id AND REVOKED_AT IS NULL
+	`, sql.Named("id", id))
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("active api key with id %d not found", id)
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &key.CreatedBy, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan api key: %w", err)
+	}
+
+	key.Scopes = []string{}
+	for _, s := range strings.Split(scopes, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			key.Scopes = append(key.Scopes, s)
+		}
+	}
+	return &key, nil
+}
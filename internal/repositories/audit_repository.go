@@ -0,0 +1,106 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"RemainsManager/internal/models"
+)
+
+// AuditWriter сохраняет записи журнала аудита
+type AuditWriter interface {
+	WriteAudit(ctx context.Context, entry *models.AuditEntry) error
+}
+
+type AuditRepository struct {
+	db      *sql.DB
+	timeout int
+}
+
+func NewAuditRepository(timeout int, db *sql.DB) *AuditRepository {
+	return &AuditRepository{db: db, timeout: timeout}
+}
+
+func (r *AuditRepository) WriteAudit(ctx context.Context, entry *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO AUDIT_LOG (USER_NAME, IP, ACTION, METHOD, ROUTE, ENTITY_ID, STATUS, OUTCOME, DETAILS)
+		VALUES (@user, @ip, @action, NULLIF(@method, ''), NULLIF(@route, ''), NULLIF(@entity_id, ''),
+		        NULLIF(@status, 0), @outcome, NULLIF(@details, ''))
+	`,
+		sql.Named("user", truncate(entry.User, 100)),
+		sql.Named("ip", truncate(entry.IP, 64)),
+		sql.Named("action", entry.Action),
+		sql.Named("method", entry.Method),
+		sql.Named("route", truncate(entry.Route, 200)),
+		sql.Named("entity_id", truncate(entry.EntityID, 100)),
+		sql.Named("status", entry.Status),
+		sql.Named("outcome", entry.Outcome),
+		sql.Named("details", truncate(entry.Details, 500)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditLog возвращает записи за период [from, to] от новых к старым
+func (r *AuditRepository) GetAuditLog(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT TOP (@limit)
+			ID_AUDIT_LOG, CREATED_AT, USER_NAME, IP, ACTION,
+			ISNULL(METHOD, ''), ISNULL(ROUTE, ''), ISNULL(ENTITY_ID, ''), ISNULL(STATUS, 0),
+			OUTCOME, ISNULL(DETAILS, '')
+		FROM AUDIT_LOG
+		WHERE CREATED_AT >= @from AND CREATED_AT < @to
+		  AND (@user = '' OR USER_NAME = @user)
+		  AND (@action = '' OR ACTION = @action)
+		ORDER BY CREATED_AT DESC, ID_AUDIT_LOG DESC
+	`,
+		sql.Named("limit", filter.Limit),
+		sql.Named("from", filter.From),
+		sql.Named("to", filter.To),
+		sql.Named("user", filter.User),
+		sql.Named("action", filter.Action),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		if err := rows.Scan(
+			&e.ID, &e.CreatedAt, &e.User, &e.IP, &e.Action,
+			&e.Method, &e.Route, &e.EntityID, &e.Status,
+			&e.Outcome, &e.Details,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return entries, nil
+}
+
+// truncate обрезает строку до длины столбца
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max])
+}
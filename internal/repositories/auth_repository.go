@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"time"
+
+	"RemainsManager/internal/models"
 )
 
 type AuthRepository struct {
@@ -15,13 +17,15 @@ func NewAuthRepository(timeout int, db *sql.DB) *AuthRepository {
 	return &AuthRepository{timeout: timeout, db: db}
 }
 
-func (r *AuthRepository) GetUserByUsername(username string) (string, error) {
+// GetUserByUsername возвращает пользователя вместе с хешем пароля
+func (r *AuthRepository) GetUserByUsername(username string) (*models.UserCredentials, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeout)*time.Second)
 	defer cancel()
-	var password string
-	err := r.db.QueryRowContext(ctx, "EXEC GetUserByUsername @Username=@username", sql.Named("username", username)).Scan(&password)
+	var c models.UserCredentials
+	err := r.db.QueryRowContext(ctx, "EXEC GetUserByUsername @Username=@username", sql.Named("username", username)).
+		Scan(&c.PasswordHash, &c.Name, &c.FullName, &c.UserNum)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return password, nil
+	return &c, nil
 }
@@ -3,8 +3,12 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
 	"RemainsManager/internal/models"
@@ -19,58 +23,176 @@ func NewOfferRepository(timeout int, db *sql.DB) *OfferRepository {
 	return &OfferRepository{db: db, timeout: timeout}
 }
 
-// GetOrCreateTodayOffer возвращает существующую или создаёт новую заявку на сегодня
+// GetOrCreateTodayOffer возвращает существующую или создаёт новую заявку на сегодня.
+// Это черновик по умолчанию (IS_DEFAULT); именованные черновики создаёт CreateDraft.
+// Поиск и вставка идут в одной транзакции под блокировкой диапазона, а уникальный индекс
+// UX_OFFER_DEFAULT_PER_DAY не даёт создать вторую заявку, если блокировка не сработала:
+// тогда запрос повторяется и возвращает заявку, созданную параллельно.
 func (r *OfferRepository) GetOrCreateTodayOffer(ctx context.Context, fromID, fromName string) (*models.Offer, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
 	defer cancel()
 
-	var offer models.Offer
+	name := time.Now().Format("02.01.2006") + " - " + fromName
 
-	err := r.db.QueryRowContext(ctx, `
-		SELECT ID_OFFER, NAME, ID_CONTRACTOR_GLOBAL_FROM, CREATED_AT
-		FROM OFFER
+	offer, err := r.getOrCreateDefaultOffer(ctx, fromID, name)
+	if isUniqueViolation(err) {
+		log.Printf("[Offer] Today's offer for %s was created concurrently, reloading", fromID)
+		offer, err = r.getOrCreateDefaultOffer(ctx, fromID, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := r.loadOfferItems(ctx, offer.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load offer items: %w", err)
+	}
+	if items == nil {
+		items = []models.OfferItem{}
+	}
+	offer.OfferItems = items
+
+	return offer, nil
+}
+
+// getOrCreateDefaultOffer атомарно находит или вставляет открытую заявку отправителя по умолчанию за сегодня
+func (r *OfferRepository) getOrCreateDefaultOffer(ctx context.Context, fromID, name string) (*models.Offer, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Отработанные и удалённые заявки не редактируются — для них создаётся новая.
+	// HOLDLOCK держит диапазон ключа до конца транзакции, даже если заявки ещё нет.
+	var offer models.Offer
+	err = tx.QueryRowContext(ctx, `
+		SELECT TOP 1 ID_OFFER, NAME, CAST(ID_CONTRACTOR_GLOBAL_FROM AS VARCHAR(36)), CREATED_AT, STATUS, IS_DEFAULT
+		FROM OFFER WITH (UPDLOCK, HOLDLOCK)
 		WHERE ID_CONTRACTOR_GLOBAL_FROM = @from_id
-		  AND CAST(CREATED_AT AS DATE) = CAST(GETDATE() AS DATE)
-	`, sql.Named("from_id", fromID)).Scan(&offer.ID, &offer.Name, &offer.IdContractorGlobalFrom, &offer.CreatedAt)
+		  AND IS_DEFAULT = 1
+		  AND OFFER_DATE = CAST(GETDATE() AS DATE)
+		  AND STATUS IN (@status_new, @status_sent, @status_error)
+		ORDER BY ID_OFFER DESC
+	`,
+		sql.Named("from_id", fromID),
+		sql.Named("status_new", models.OfferStatusNew),
+		sql.Named("status_sent", models.OfferStatusSent),
+		sql.Named("status_error", models.OfferStatusError),
+	).Scan(&offer.ID, &offer.Name, &offer.IdContractorGlobalFrom, &offer.CreatedAt, &offer.Status, &offer.IsDefault)
 
-	if err == nil {
-		// Заявка найдена — загружаем позиции
-		items, err := r.loadOfferItems(ctx, offer.ID)
+	switch {
+	case err == sql.ErrNoRows:
+		// Заявка не найдена — создаём новую
+		created, err := createOffer(ctx, tx, fromID, name, true)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load offer items: %w", err)
+			return nil, err
 		}
-		offer.OfferItems = items
-		return &offer, nil
+		offer = *created
+	case err != nil:
+		return nil, fmt.Errorf("database error: %w", err)
 	}
 
-	if err != sql.ErrNoRows {
-		return nil, fmt.Errorf("database error: %w", err)
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	// Заявка не найдена — создаём новую
-	name := time.Now().Format("02.01.2006") + " - " + fromName
+	return &offer, nil
+}
+
+// CreateDraft создаёт именованный черновик заявки отправителя
+func (r *OfferRepository) CreateDraft(ctx context.Context, fromID, name string) (*models.Offer, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	return createOffer(ctx, r.db, fromID, name, false)
+}
+
+// rowQuerier — *sql.DB или *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// rowsQuerier — *sql.DB или *sql.Tx
+type rowsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
 
-	var newID int64
-	err = r.db.QueryRowContext(ctx, `
-		INSERT INTO OFFER (NAME, ID_CONTRACTOR_GLOBAL_FROM, CREATED_AT, STATUS)
-		OUTPUT INSERTED.ID_OFFER
-		VALUES (@name, @from_id, GETDATE(), 0)
-	`, sql.Named("name", name), sql.Named("from_id", fromID)).Scan(&newID)
+// createOffer вставляет новую заявку в статусе "новая"
+func createOffer(ctx context.Context, q rowQuerier, fromID, name string, isDefault bool) (*models.Offer, error) {
+	var offer models.Offer
+	err := q.QueryRowContext(ctx, `
+		INSERT INTO OFFER (NAME, ID_CONTRACTOR_GLOBAL_FROM, CREATED_AT, OFFER_DATE, STATUS, IS_DEFAULT)
+		OUTPUT INSERTED.ID_OFFER, INSERTED.CREATED_AT
+		VALUES (@name, @from_id, GETDATE(), CAST(GETDATE() AS DATE), @status, @is_default)
+	`,
+		sql.Named("name", name),
+		sql.Named("from_id", fromID),
+		sql.Named("status", models.OfferStatusNew),
+		sql.Named("is_default", isDefault),
+	).Scan(&offer.ID, &offer.CreatedAt)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create offer: %w", err)
 	}
 
-	offer.ID = newID
 	offer.Name = name
 	offer.IdContractorGlobalFrom = fromID
-	offer.CreatedAt = time.Now()
+	offer.Status = models.OfferStatusNew
+	offer.IsDefault = isDefault
 	offer.OfferItems = []models.OfferItem{}
 
 	return &offer, nil
 }
 
-// AddItems обновляет или добавляет позиции в заявку (объединяет по GOODS_ID)
+// GetOpenDrafts возвращает редактируемые заявки отправителя (новые, отправленные, с ошибкой)
+// от новых к старым с количеством позиций
+func (r *OfferRepository) GetOpenDrafts(ctx context.Context, fromID string) ([]models.OfferDraft, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT o.ID_OFFER, o.NAME, o.CREATED_AT, o.STATUS, o.IS_DEFAULT,
+		       COUNT(oi.ID_OFFER_ITEM), ISNULL(SUM(oi.QUANTITY), 0)
+		FROM OFFER o
+		LEFT JOIN OFFER_ITEM oi ON oi.ID_OFFER = o.ID_OFFER
+		WHERE o.ID_CONTRACTOR_GLOBAL_FROM = @from_id
+		  AND o.STATUS IN (@status_new, @status_sent, @status_error)
+		GROUP BY o.ID_OFFER, o.NAME, o.CREATED_AT, o.STATUS, o.IS_DEFAULT
+		ORDER BY o.CREATED_AT DESC, o.ID_OFFER DESC
+	`,
+		sql.Named("from_id", fromID),
+		sql.Named("status_new", models.OfferStatusNew),
+		sql.Named("status_sent", models.OfferStatusSent),
+		sql.Named("status_error", models.OfferStatusError),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query open drafts: %w", err)
+	}
+	defer rows.Close()
+
+	drafts := []models.OfferDraft{}
+	for rows.Next() {
+		var d models.OfferDraft
+		if err := rows.Scan(&d.ID, &d.Name, &d.CreatedAt, &d.Status, &d.IsDefault, &d.ItemCount, &d.Quantity); err != nil {
+			return nil, fmt.Errorf("failed to scan draft: %w", err)
+		}
+		drafts = append(drafts, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return drafts, nil
+}
+
+// AddItems обновляет или добавляет позиции в заявку (объединяет по GOODS_ID).
+// Если заявка уже не редактируется, возвращает *models.OfferStateError,
+// если перемещение получателю уже сохранено — *models.ReceiverSavedError,
+// если отправитель позиции не совпадает с отправителем заявки или её партия лежит
+// не на его складах — *models.OfferValidationError,
+// если партии не хватает с учётом резервов других заявок — *models.OverAllocationError.
 func (r *OfferRepository) AddItems(ctx context.Context, items []models.OfferItem) error {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
 	defer cancel()
@@ -81,6 +203,43 @@ func (r *OfferRepository) AddItems(ctx context.Context, items []models.OfferItem
 	}
 	defer tx.Rollback()
 
+	saved := make(map[int64]map[string]bool)
+	senders := make(map[int64]string)
+	for _, item := range items {
+		if _, ok := saved[item.OfferID]; ok {
+			continue
+		}
+		if err := lockEditableOffer(ctx, tx, item.OfferID); err != nil {
+			return err
+		}
+		receivers, err := savedReceivers(ctx, tx, item.OfferID)
+		if err != nil {
+			return err
+		}
+		saved[item.OfferID] = receivers
+		sender, err := offerSender(ctx, tx, item.OfferID)
+		if err != nil {
+			return err
+		}
+		senders[item.OfferID] = sender
+	}
+	for _, item := range items {
+		if saved[item.OfferID][strings.ToUpper(item.IdContractorGlobalTo)] {
+			return &models.ReceiverSavedError{OfferID: item.OfferID, ContractorTo: item.IdContractorGlobalTo}
+		}
+	}
+	if err := checkItemOwnership(ctx, tx, items, senders); err != nil {
+		return err
+	}
+
+	lots := make([]string, 0, len(items))
+	for _, item := range items {
+		lots = append(lots, item.IdLotGlobal)
+	}
+	if err := lockLotReservations(ctx, tx, lots); err != nil {
+		return err
+	}
+
 	for _, item := range items {
 		_, err := tx.ExecContext(ctx, `
 			IF EXISTS (
@@ -107,6 +266,27 @@ func (r *OfferRepository) AddItems(ctx context.Context, items []models.OfferItem
 		}
 	}
 
+	checked := make(map[string]bool)
+	var over []models.LotAllocation
+	for _, item := range items {
+		key := fmt.Sprintf("%d/%s", item.OfferID, strings.ToUpper(item.IdLotGlobal))
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+
+		alloc, err := lotAllocation(ctx, tx, item.OfferID, item.IdLotGlobal)
+		if err != nil {
+			return err
+		}
+		if alloc.Requested > alloc.Available {
+			over = append(over, *alloc)
+		}
+	}
+	if len(over) > 0 {
+		return &models.OverAllocationError{Lots: over}
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -138,73 +318,190 @@ func (r *OfferRepository) loadOfferItems(ctx context.Context, offerID int64) ([]
 	return items, nil
 }
 
-// GetOfferJournal возвращает журнал заявок с фильтрацией по диапазону дат
-// GetOfferJournal возвращает журнал заявок с фильтрацией по датам и контрагенту
-func (r *OfferRepository) GetOfferJournal(ctx context.Context, from, to time.Time, contractorGlobal *string) ([]models.OfferJournalItem, error) {
+// journalSortColumns — допустимые столбцы сортировки журнала
+var journalSortColumns = map[string]string{
+	models.JournalSortDate:   "o.CREATED_AT",
+	models.JournalSortSender: "c.NAME",
+	models.JournalSortTotal:  "t.TOTAL_RETAIL",
+}
+
+// GetOfferJournal возвращает страницу журнала заявок и общее число заявок по фильтру.
+// Для каждой заявки считаются позиции, получатели, количество и сумма в розничных ценах.
+func (r *OfferRepository) GetOfferJournal(ctx context.Context, filter models.OfferJournalFilter) ([]models.OfferJournalItem, int, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
 	defer cancel()
 
-	// Базовый запрос
+	// Ограничение по закреплённым аптекам
+	if filter.Allowed != nil && len(filter.Allowed) == 0 {
+		return []models.OfferJournalItem{}, 0, nil
+	}
+
+	where := " WHERE CAST(o.CREATED_AT AS DATE) BETWEEN @from AND @to"
+	args := []interface{}{
+		sql.Named("from", filter.From.Format("2006-01-02")),
+		sql.Named("to", filter.To.Format("2006-01-02")),
+	}
+
+	// Добавляем фильтр по контрагенту, если указан
+	if filter.Contractor != "" {
+		where += " AND o.ID_CONTRACTOR_GLOBAL_FROM = @contractor_global"
+		args = append(args, sql.Named("contractor_global", filter.Contractor))
+	}
+	if filter.Allowed != nil {
+		inClause, inArgs := namedInClause("allowed", filter.Allowed)
+		where += " AND o.ID_CONTRACTOR_GLOBAL_FROM IN (" + inClause + ")"
+		args = append(args, inArgs...)
+	}
+	if len(filter.Statuses) > 0 {
+		names := make([]string, 0, len(filter.Statuses))
+		for i, st := range filter.Statuses {
+			name := fmt.Sprintf("status%d", i)
+			names = append(names, "@"+name)
+			args = append(args, sql.Named(name, st))
+		}
+		where += " AND o.STATUS IN (" + strings.Join(names, ", ") + ")"
+	}
+	if filter.Receiver != "" {
+		where += ` AND EXISTS (
+			SELECT 1 FROM OFFER_ITEM ri
+			WHERE ri.ID_OFFER = o.ID_OFFER AND ri.ID_CONTRACTOR_GLOBAL_TO = @receiver)`
+		args = append(args, sql.Named("receiver", filter.Receiver))
+	}
+	if filter.Search != "" {
+		where += ` AND o.NAME LIKE '%' + @search + '%' ESCAPE '\'`
+		args = append(args, sql.Named("search", escapeLike(filter.Search)))
+	}
+
+	var total int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM OFFER o
+		INNER JOIN CONTRACTOR c ON c.ID_CONTRACTOR_GLOBAL = o.ID_CONTRACTOR_GLOBAL_FROM
+	`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count journal: %w", err)
+	}
+
+	sortColumn, ok := journalSortColumns[filter.Sort]
+	if !ok {
+		sortColumn = journalSortColumns[models.JournalSortDate]
+	}
+	direction := "ASC"
+	if filter.Desc {
+		direction = "DESC"
+	}
+
 	query := `
 		SELECT 
 			o.ID_OFFER,
 			o.NAME AS mnemocode,
 			c.NAME AS contractor,
 			CAST(o.CREATED_AT AS DATE) AS created,
-			o.STATUS
+			o.STATUS,
+			t.ITEM_COUNT,
+			t.RECEIVER_COUNT,
+			t.TOTAL_QUANTITY,
+			t.TOTAL_RETAIL,
+			h.CHANGED_AT,
+			ISNULL(h.USER_NAME, ''),
+			ISNULL(h.COMMENT, ''),
+			ISNULL(h.ERROR_MESSAGE, '')
 		FROM OFFER o
 		INNER JOIN CONTRACTOR c ON c.ID_CONTRACTOR_GLOBAL = o.ID_CONTRACTOR_GLOBAL_FROM
-		WHERE CAST(o.CREATED_AT AS DATE) BETWEEN @from AND @to
+		OUTER APPLY (
+			SELECT
+				COUNT(*) AS ITEM_COUNT,
+				COUNT(DISTINCT oi.ID_CONTRACTOR_GLOBAL_TO) AS RECEIVER_COUNT,
+				ISNULL(SUM(oi.QUANTITY), 0) AS TOTAL_QUANTITY,
+				ISNULL(SUM(oi.QUANTITY * ISNULL(l.PRICE_SAL, 0)), 0) AS TOTAL_RETAIL
+			FROM OFFER_ITEM oi
+			LEFT JOIN LOT l ON l.ID_LOT_GLOBAL = oi.ID_LOT_GLOBAL
+			WHERE oi.ID_OFFER = o.ID_OFFER
+		) t
+		OUTER APPLY (
+			SELECT TOP 1 CHANGED_AT, USER_NAME, COMMENT, ERROR_MESSAGE
+			FROM OFFER_STATUS_HISTORY
+			WHERE ID_OFFER = o.ID_OFFER
+			ORDER BY CHANGED_AT DESC, ID_OFFER_STATUS_HISTORY DESC
+		) h
+	` + where + `
+		ORDER BY ` + sortColumn + ` ` + direction + `, o.ID_OFFER ` + direction + `
+		OFFSET @offset ROWS
+		FETCH NEXT @limit ROWS ONLY
 	`
+	args = append(args,
+		sql.Named("offset", (filter.Page-1)*filter.Limit),
+		sql.Named("limit", filter.Limit),
+	)
 
-	// Добавляем фильтр по контрагенту, если указан
-	if contractorGlobal != nil && *contractorGlobal != "" {
-		query += " AND o.ID_CONTRACTOR_GLOBAL_FROM = @contractor_global"
-	}
-
-	query += " ORDER BY o.CREATED_AT DESC"
-
-	// Подготавливаем параметры
-	var rows *sql.Rows
-	var err error
-
-	if contractorGlobal != nil && *contractorGlobal != "" {
-		rows, err = r.db.QueryContext(ctx, query,
-			sql.Named("from", from.Format("2006-01-02")),
-			sql.Named("to", to.Format("2006-01-02")),
-			sql.Named("contractor_global", *contractorGlobal),
-		)
-	} else {
-		rows, err = r.db.QueryContext(ctx, query,
-			sql.Named("from", from.Format("2006-01-02")),
-			sql.Named("to", to.Format("2006-01-02")),
-		)
-	}
-
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute journal query: %w", err)
+		return nil, 0, fmt.Errorf("failed to execute journal query: %w", err)
 	}
 	defer rows.Close()
 
-	var items []models.OfferJournalItem
+	items := []models.OfferJournalItem{}
 	for rows.Next() {
 		var item models.OfferJournalItem
-		err := rows.Scan(&item.ID, &item.Mnemocode, &item.Contractor, &item.CreatedAt, &item.Status)
+		err := rows.Scan(&item.ID, &item.Mnemocode, &item.Contractor, &item.CreatedAt, &item.Status,
+			&item.ItemCount, &item.ReceiverCount, &item.TotalQuantity, &item.TotalRetail,
+			&item.LastChangedAt, &item.LastChangedBy, &item.LastComment, &item.LastError)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
 		}
 		items = append(items, item)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows iteration error: %w", err)
+		return nil, 0, fmt.Errorf("rows iteration error: %w", err)
 	}
 
-	return items, nil
+	return items, total, nil
+}
+
+// GetOffer возвращает шапку заявки без позиций
+func (r *OfferRepository) GetOffer(ctx context.Context, offerID int64) (*models.Offer, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	var offer models.Offer
+	err := r.db.QueryRowContext(ctx, `
+		SELECT ID_OFFER, NAME, CAST(ID_CONTRACTOR_GLOBAL_FROM AS VARCHAR(36)), CREATED_AT, STATUS, IS_DEFAULT
+		FROM OFFER
+		WHERE ID_OFFER = @id
+	`, sql.Named("id", offerID)).Scan(&offer.ID, &offer.Name, &offer.IdContractorGlobalFrom, &offer.CreatedAt, &offer.Status, &offer.IsDefault)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("offer with id %d not found", offerID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offer: %w", err)
+	}
+
+	return &offer, nil
+}
+
+// GetOfferByItem возвращает шапку заявки, которой принадлежит позиция
+func (r *OfferRepository) GetOfferByItem(ctx context.Context, itemID int64) (*models.Offer, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	var offerID int64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT ID_OFFER FROM OFFER_ITEM WHERE ID_OFFER_ITEM = @id
+	`, sql.Named("id", itemID)).Scan(&offerID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("offer item with id %d not found", itemID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offer item: %w", err)
+	}
+
+	return r.GetOffer(ctx, offerID)
 }
 
-// GetOfferDetails возвращает детали заявки по ID
-func (r *OfferRepository) GetOfferDetails(ctx context.Context, offerID int64) ([]models.OfferDetailItem, error) {
+// GetOfferDetails возвращает детали заявки по ID: партию, цены и срок годности каждой позиции,
+// остаток партии у отправителя, а также остаток товара и продажи получателя за salesDays дней
+func (r *OfferRepository) GetOfferDetails(ctx context.Context, offerID int64, salesDays int) ([]models.OfferDetailItem, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
 	defer cancel()
 
@@ -212,30 +509,69 @@ func (r *OfferRepository) GetOfferDetails(ctx context.Context, offerID int64) ([
 		SELECT 
 			CONCAT(g.NAME, ' | ', p.NAME) AS goods_name,
 			c.NAME AS contractor_to,
+			CAST(oi.ID_CONTRACTOR_GLOBAL_TO AS VARCHAR(36)),
 			oi.QUANTITY AS quantity,
-			oi.id_offer_item as itemId
+			oi.id_offer_item as itemId,
+			CAST(oi.ID_LOT_GLOBAL AS VARCHAR(36)),
+			ISNULL(l.LOT_NAME, ''),
+			ISNULL(l.INTERNAL_BARCODE, ''),
+			ISNULL(l.PRICE_SAL, 0),
+			ISNULL(l.PRICE_PROD, 0),
+			oi.QUANTITY * ISNULL(l.PRICE_SAL, 0),
+			ISNULL(CONVERT(VARCHAR(10), s.BEST_BEFORE, 23), ''),
+			ISNULL(l.QUANTITY_REM, 0),
+			ISNULL(rs.QTY, 0),
+			ISNULL(rsl.SOLD, 0)
 		FROM OFFER o
 		INNER JOIN OFFER_ITEM oi ON o.ID_OFFER = oi.ID_OFFER
 		INNER JOIN CONTRACTOR c ON c.ID_CONTRACTOR_GLOBAL = oi.ID_CONTRACTOR_GLOBAL_TO
 		INNER JOIN GOODS g ON g.ID_GOODS_GLOBAL = oi.GOODS_ID
 		INNER JOIN PRODUCER p ON p.ID_PRODUCER = g.ID_PRODUCER
+		LEFT JOIN LOT l ON l.ID_LOT_GLOBAL = oi.ID_LOT_GLOBAL
+		LEFT JOIN SERIES s ON s.ID_SERIES = l.ID_SERIES
+		OUTER APPLY (
+			SELECT SUM(rl.QUANTITY_REM) AS QTY
+			FROM LOT rl
+			INNER JOIN STORE rst ON rst.ID_STORE = rl.ID_STORE
+			WHERE rst.ID_CONTRACTOR = c.ID_CONTRACTOR
+			  AND rl.ID_GOODS = g.ID_GOODS
+			  AND rl.QUANTITY_REM > 0
+		) rs
+		OUTER APPLY (
+			SELECT SUM(lm.QUANTITY_SUB) AS SOLD
+			FROM LOT_MOVEMENT lm
+			INNER JOIN LOT ml ON ml.ID_LOT_GLOBAL = lm.ID_LOT_GLOBAL
+			INNER JOIN STORE mst ON mst.ID_STORE = ml.ID_STORE
+			WHERE mst.ID_CONTRACTOR = c.ID_CONTRACTOR
+			  AND ml.ID_GOODS = g.ID_GOODS
+			  AND lm.CODE_OP IN ('CHEQUE', 'INVOICE_OUT')
+			  AND lm.DATE_OP >= DATEADD(DAY, -@days, CAST(GETDATE() AS DATE))
+		) rsl
 		WHERE o.ID_OFFER = @offer_id
 		ORDER BY g.NAME
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, sql.Named("offer_id", offerID))
+	rows, err := r.db.QueryContext(ctx, query,
+		sql.Named("offer_id", offerID),
+		sql.Named("days", salesDays),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute details query: %w", err)
 	}
 	defer rows.Close()
 
-	var items []models.OfferDetailItem
+	items := []models.OfferDetailItem{}
 	for rows.Next() {
 		var item models.OfferDetailItem
-		err := rows.Scan(&item.GoodsName, &item.ContractorTo, &item.Quantity, &item.ID)
+		err := rows.Scan(&item.GoodsName, &item.ContractorTo, &item.ContractorToID, &item.Quantity, &item.ID,
+			&item.LotGlobal, &item.LotName, &item.InternalBarcode, &item.PriceSal, &item.PriceProd, &item.LineValue,
+			&item.BestBefore, &item.SenderRemains, &item.ReceiverStock, &item.ReceiverSold)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan detail row: %w", err)
 		}
+		if salesDays > 0 {
+			item.ReceiverSalesPerDay = math.Round(item.ReceiverSold/float64(salesDays)*100) / 100
+		}
 		items = append(items, item)
 	}
 
@@ -246,12 +582,43 @@ func (r *OfferRepository) GetOfferDetails(ctx context.Context, offerID int64) ([
 	return items, nil
 }
 
-// UpdateOfferItem обновляет количество позиции в заявке
+// UpdateOfferItem обновляет количество позиции в заявке.
+// Если заявка уже не редактируется, возвращает *models.OfferStateError,
+// если перемещение получателю позиции уже сохранено — *models.ReceiverSavedError,
+// если увеличенное количество не покрывается остатком партии — *models.OverAllocationError.
 func (r *OfferRepository) UpdateOfferItem(ctx context.Context, id int64, quantity int) error {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
 	defer cancel()
 
-	result, err := r.db.ExecContext(ctx, `
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	offerID, err := lockEditableOfferByItem(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	var lot string
+	var current int
+	err = tx.QueryRowContext(ctx, `
+		SELECT CAST(ID_LOT_GLOBAL AS VARCHAR(36)), QUANTITY FROM OFFER_ITEM WHERE ID_OFFER_ITEM = @id
+	`, sql.Named("id", id)).Scan(&lot, &current)
+	if err != nil {
+		return fmt.Errorf("failed to get offer item: %w", err)
+	}
+
+	// Уменьшение не проверяем: оно только освобождает резерв
+	increase := quantity > current
+	if increase {
+		if err := lockLotReservations(ctx, tx, []string{lot}); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
 		UPDATE OFFER_ITEM 
 		SET QUANTITY = @quantity 
 		WHERE ID_OFFER_ITEM = @id`,
@@ -262,24 +629,41 @@ func (r *OfferRepository) UpdateOfferItem(ctx context.Context, id int64, quantit
 		return fmt.Errorf("failed to update offer item: %w", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to check rows affected: %w", err)
+	if increase {
+		alloc, err := lotAllocation(ctx, tx, offerID, lot)
+		if err != nil {
+			return err
+		}
+		if alloc.Requested > alloc.Available {
+			return &models.OverAllocationError{Lots: []models.LotAllocation{*alloc}}
+		}
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("offer item with id %d not found", id)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-// DeleteOfferItem удаляет позицию из заявки по ID
+// DeleteOfferItem удаляет позицию из заявки по ID.
+// Если заявка уже не редактируется, возвращает *models.OfferStateError,
+// если перемещение получателю позиции уже сохранено — *models.ReceiverSavedError.
 func (r *OfferRepository) DeleteOfferItem(ctx context.Context, id int64) error {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
 	defer cancel()
 
-	result, err := r.db.ExecContext(ctx, `
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := lockEditableOfferByItem(ctx, tx, id); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
 		DELETE FROM OFFER_ITEM 
 		WHERE ID_OFFER_ITEM = @id`,
 		sql.Named("id", id),
@@ -288,126 +672,1209 @@ func (r *OfferRepository) DeleteOfferItem(ctx context.Context, id int64) error {
 		return fmt.Errorf("failed to delete offer item: %w", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to check rows affected: %w", err)
-	}
-
-	if rowsAffected == 0 {
-		return fmt.Errorf("offer item with id %d not found", id)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
-
 }
 
-// UpdateOfferStatus обновляет статус заявки по ID
-func (r *OfferRepository) UpdateOfferStatus(ctx context.Context, offerID int64, status int) error {
+// EditItems применяет массовую правку позиций заявки offerID в одной транзакции.
+// plan получает текущие позиции заявки (под блокировкой) и возвращает правки; позиции получателей
+// с уже сохранённым перемещением в plan не передаются, а явные правки их позиций не проходят проверку.
+// Если хоть одна правка не проходит проверку или партиям не хватает остатка,
+// транзакция откатывается и возвращается *models.OfferBulkEditError с итогом по каждой позиции.
+func (r *OfferRepository) EditItems(ctx context.Context, offerID int64, plan func([]models.OfferItem) []models.OfferItemPatch) (*models.OfferBulkResult, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
 	defer cancel()
 
-	result, err := r.db.ExecContext(ctx, `
-		UPDATE OFFER 
-		SET STATUS = @status 
-		WHERE ID_OFFER = @id`,
-		sql.Named("status", status),
-		sql.Named("id", offerID),
-	)
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to update offer status: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to check rows affected: %w", err)
+	if err := lockEditableOffer(ctx, tx, offerID); err != nil {
+		return nil, err
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("offer with id %d not found", offerID)
+	items, err := lockOfferItems(ctx, tx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := savedReceivers(ctx, tx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]models.OfferItem, len(items))
+	editable := make([]models.OfferItem, 0, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+		if !saved[strings.ToUpper(item.IdContractorGlobalTo)] {
+			editable = append(editable, item)
+		}
 	}
 
-	return nil
-}
+	patches := plan(editable)
+	results := make([]models.OfferItemResult, len(patches))
+	receivers := make(map[string]bool)
+	seen := make(map[int64]bool)
+	failed := false
+	for i, p := range patches {
+		res := &results[i]
+		res.ItemID = p.ItemID
+		res.Status = models.BulkItemApplied
 
-// ProcessOffer вызывает usp_GenerateInterfirmMovingFromOffer и возвращает сырые строки
-func (r *OfferRepository) ProcessOffer(ctx context.Context, offerID int64) ([]models.InterfirmRow, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
-	defer cancel()
+		item, ok := byID[p.ItemID]
+		switch {
+		case !ok:
+			res.Error = fmt.Sprintf("offer item with id %d not found in offer %d", p.ItemID, offerID)
+		case seen[p.ItemID]:
+			res.Error = "item is patched more than once"
+		case !p.Delete && p.Quantity == nil && p.ContractorTo == nil:
+			res.Error = "patch changes nothing: set quantity, receiver or delete"
+		case p.Delete && (p.Quantity != nil || p.ContractorTo != nil):
+			res.Error = "delete cannot be combined with quantity or receiver"
+		case p.Quantity != nil && *p.Quantity <= 0:
+			res.Error = "quantity must be greater than 0"
+		case p.FromReceiver != "" && !strings.EqualFold(p.FromReceiver, item.IdContractorGlobalTo):
+			res.Error = fmt.Sprintf("offer item %d does not belong to receiver %s", p.ItemID, p.FromReceiver)
+		case saved[strings.ToUpper(item.IdContractorGlobalTo)]:
+			res.Error = fmt.Sprintf("interfirm moving for receiver %s is already saved", item.IdContractorGlobalTo)
+		case p.ContractorTo != nil && strings.EqualFold(*p.ContractorTo, item.IdContractorGlobalFrom):
+			res.Error = "receiver must differ from the sender"
+		case p.ContractorTo != nil && saved[strings.ToUpper(*p.ContractorTo)]:
+			res.Error = fmt.Sprintf("interfirm moving for receiver %s is already saved", *p.ContractorTo)
+		}
+		seen[p.ItemID] = true
 
-	rows, err := r.db.QueryContext(ctx, `
-		EXEC usp_GenerateInterfirmMovingFromOffer @id_offer = @offer_id
-	`, sql.Named("offer_id", offerID))
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute usp_GenerateInterfirmMovingFromOffer: %w", err)
+		res.QuantityBefore = item.Quantity
+		res.QuantityAfter = item.Quantity
+		res.ContractorTo = item.IdContractorGlobalTo
+		switch {
+		case p.Delete:
+			res.Action = models.BulkActionDelete
+			res.QuantityAfter = 0
+			res.ContractorTo = ""
+		case p.ContractorTo != nil && !strings.EqualFold(*p.ContractorTo, item.IdContractorGlobalTo):
+			res.Action = models.BulkActionMove
+			res.ContractorTo = *p.ContractorTo
+			receivers[strings.ToUpper(*p.ContractorTo)] = true
+		default:
+			res.Action = models.BulkActionUpdate
+		}
+		if p.Quantity != nil {
+			res.QuantityAfter = *p.Quantity
+		}
+
+		if res.Error != "" {
+			res.Status = models.BulkItemFailed
+			failed = true
+		}
 	}
-	defer rows.Close()
 
-	var results []models.InterfirmRow
-	for rows.Next() {
-		var row models.InterfirmRow
-		err := rows.Scan(
-			&row.IDContractorGlobalTo,
-			&row.IDInterfirmMoving,
-			&row.IDInterfirmMovingGlobal,
-			&row.Mnemocode,
-			&row.IDStoreFromMain,
-			&row.IDStoreFromTransit,
-			&row.IDContractorTo,
-			&row.IDStoreToMain,
-			&row.IDStoreToTransit,
-			&row.Date,
-			&row.DocumentState,
-			&row.Comment,
-			&row.IDUser,
-			&row.IDUser2,
-			&row.SumSupplierHeader,
-			&row.SVatSupplierHeader,
-			&row.SumRetailHeader,
-			&row.SVatRetailHeader,
-			&row.GoodsSent,
-			&row.AuthNum,
-			&row.AuthValidPeriod,
-			&row.IDInterfirmMovingItem,
-			&row.IDInterfirmMovingItemGlobal,
-			&row.Quantity,
-			&row.IDLotFrom,
-			&row.IDLotTo,
-			&row.SumSupplier,
-			&row.SVatSupplier,
-			&row.PVatRetail,
-			&row.VatRetail,
-			&row.IsWeight,
-			&row.Kiz,   // ← NULL
-			&row.IsKiz, // ← 1 или 0
-		)
+	// Новые получатели должны существовать
+	for receiver := range receivers {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT CASE WHEN EXISTS (SELECT 1 FROM CONTRACTOR WHERE ID_CONTRACTOR_GLOBAL = @id) THEN 1 ELSE 0 END
+		`, sql.Named("id", receiver)).Scan(&exists)
 		if err != nil {
-			return nil, fmt.Errorf("scan error: %w", err)
+			return nil, fmt.Errorf("failed to check contractor %s: %w", receiver, err)
+		}
+		if exists {
+			continue
+		}
+		for i := range results {
+			if results[i].Action == models.BulkActionMove && strings.EqualFold(results[i].ContractorTo, receiver) {
+				results[i].Status = models.BulkItemFailed
+				results[i].Error = fmt.Sprintf("contractor with id %s not found", results[i].ContractorTo)
+				failed = true
+			}
 		}
-		results = append(results, row)
 	}
 
-	if err := rows.Err(); err != nil {
+	if failed {
+		return nil, bulkEditError(offerID, results, nil)
+	}
+
+	// Резерв проверяем только по партиям, где количество растёт
+	var grown []string
+	for i, res := range results {
+		if res.Action != models.BulkActionDelete && res.QuantityAfter > res.QuantityBefore {
+			grown = append(grown, byID[patches[i].ItemID].IdLotGlobal)
+		}
+	}
+	if err := lockLotReservations(ctx, tx, grown); err != nil {
+		return nil, err
+	}
+
+	// Сначала удаления и изменения количества, затем переносы: иначе строка, в которую объединили
+	// перенесённую позицию, могла бы позже получить количество из своей правки и потерять добавленное
+	pending := make(map[int64]bool)
+	for i, p := range patches {
+		res := &results[i]
+		item := byID[p.ItemID]
+
+		switch res.Action {
+		case models.BulkActionDelete:
+			_, err = tx.ExecContext(ctx, `DELETE FROM OFFER_ITEM WHERE ID_OFFER_ITEM = @id`, sql.Named("id", item.ID))
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete offer item %d: %w", item.ID, err)
+			}
+
+		case models.BulkActionMove:
+			pending[item.ID] = true
+
+		default:
+			if res.QuantityAfter == res.QuantityBefore {
+				res.Status = models.BulkItemUnchanged
+				continue
+			}
+			_, err = tx.ExecContext(ctx, `
+				UPDATE OFFER_ITEM SET QUANTITY = @quantity WHERE ID_OFFER_ITEM = @id`,
+				sql.Named("quantity", res.QuantityAfter),
+				sql.Named("id", item.ID),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update offer item %d: %w", item.ID, err)
+			}
+		}
+	}
+
+	for i, p := range patches {
+		res := &results[i]
+		item := byID[p.ItemID]
+		if res.Action != models.BulkActionMove {
+			continue
+		}
+		delete(pending, item.ID)
+
+		// У получателя уже есть строка той же партии — объединяем, как AddItems.
+		// Строки, которые ещё уйдут от получателя в этой правке, целью не считаются.
+		targetID, err := mergeTarget(ctx, tx, offerID, item, res.ContractorTo, pending)
+		if err != nil {
+			return nil, err
+		}
+
+		if targetID == 0 {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE OFFER_ITEM SET ID_CONTRACTOR_GLOBAL_TO = @to_id, QUANTITY = @quantity
+				WHERE ID_OFFER_ITEM = @id`,
+				sql.Named("to_id", res.ContractorTo),
+				sql.Named("quantity", res.QuantityAfter),
+				sql.Named("id", item.ID),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to move offer item %d: %w", item.ID, err)
+			}
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE OFFER_ITEM SET QUANTITY = QUANTITY + @quantity WHERE ID_OFFER_ITEM = @target_id;
+			DELETE FROM OFFER_ITEM WHERE ID_OFFER_ITEM = @id`,
+			sql.Named("quantity", res.QuantityAfter),
+			sql.Named("target_id", targetID),
+			sql.Named("id", item.ID),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge offer item %d into %d: %w", item.ID, targetID, err)
+		}
+		res.Status = models.BulkItemMerged
+		res.MergedInto = targetID
+	}
+
+	checked := make(map[string]bool)
+	var over []models.LotAllocation
+	for _, lot := range grown {
+		key := strings.ToUpper(lot)
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+
+		alloc, err := lotAllocation(ctx, tx, offerID, lot)
+		if err != nil {
+			return nil, err
+		}
+		if alloc.Requested > alloc.Available {
+			over = append(over, *alloc)
+		}
+	}
+	if len(over) > 0 {
+		for i, res := range results {
+			lot := byID[patches[i].ItemID].IdLotGlobal
+			for _, alloc := range over {
+				if res.QuantityAfter > res.QuantityBefore && strings.EqualFold(alloc.LotGlobal, lot) {
+					results[i].Status = models.BulkItemFailed
+					results[i].Error = fmt.Sprintf("lot %s: requested %g, available %g", lot, alloc.Requested, alloc.Available)
+				}
+			}
+		}
+		return nil, bulkEditError(offerID, results, over)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &models.OfferBulkResult{OfferID: offerID, Items: results}, nil
+}
+
+// mergeTarget ищет у получателя toID строку заявки с тем же товаром и партией, что у item,
+// пропуская строки из skip. Возвращает 0, если такой строки нет.
+func mergeTarget(ctx context.Context, tx *sql.Tx, offerID int64, item models.OfferItem, toID string, skip map[int64]bool) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT ID_OFFER_ITEM FROM OFFER_ITEM
+		WHERE ID_OFFER = @offer_id AND GOODS_ID = @goods_id AND ID_LOT_GLOBAL = @lot
+		  AND ID_CONTRACTOR_GLOBAL_TO = @to_id AND ID_OFFER_ITEM <> @id
+		ORDER BY ID_OFFER_ITEM
+	`,
+		sql.Named("offer_id", offerID),
+		sql.Named("goods_id", item.GoodsId),
+		sql.Named("lot", item.IdLotGlobal),
+		sql.Named("to_id", toID),
+		sql.Named("id", item.ID),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find merge target for offer item %d: %w", item.ID, err)
+	}
+	defer rows.Close()
+
+	var targetID int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, fmt.Errorf("failed to scan merge target: %w", err)
+		}
+		if targetID == 0 && !skip[id] {
+			targetID = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return targetID, nil
+}
+
+// bulkEditError помечает непроверенные позиции отменёнными и собирает ошибку массовой правки
+func bulkEditError(offerID int64, results []models.OfferItemResult, lots []models.LotAllocation) *models.OfferBulkEditError {
+	for i := range results {
+		if results[i].Status != models.BulkItemFailed {
+			results[i].Status = models.BulkItemRolledBack
+			results[i].MergedInto = 0
+		}
+	}
+	return &models.OfferBulkEditError{OfferID: offerID, Items: results, Lots: lots}
+}
+
+// lockOfferItems загружает позиции заявки в транзакции tx, блокируя их до её конца
+func lockOfferItems(ctx context.Context, tx *sql.Tx, offerID int64) ([]models.OfferItem, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT ID_OFFER_ITEM,
+			CAST(ID_CONTRACTOR_GLOBAL_FROM AS VARCHAR(36)),
+			CAST(ID_CONTRACTOR_GLOBAL_TO AS VARCHAR(36)),
+			GOODS_ID,
+			QUANTITY,
+			CAST(ID_LOT_GLOBAL AS VARCHAR(36))
+		FROM OFFER_ITEM WITH (UPDLOCK)
+		WHERE ID_OFFER = @offer_id
+		ORDER BY ID_OFFER_ITEM
+	`, sql.Named("offer_id", offerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock offer items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []models.OfferItem{}
+	for rows.Next() {
+		i := models.OfferItem{OfferID: offerID}
+		if err := rows.Scan(&i.ID, &i.IdContractorGlobalFrom, &i.IdContractorGlobalTo, &i.GoodsId, &i.Quantity, &i.IdLotGlobal); err != nil {
+			return nil, fmt.Errorf("failed to scan offer item: %w", err)
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows iteration error: %w", err)
 	}
+	return items, nil
+}
 
-	return results, nil
+// lockEditableOffer блокирует строку заявки до конца транзакции и проверяет, что позиции можно менять.
+// Смена статуса (ChangeOfferStatus) ждёт эту блокировку, поэтому заявка не уйдёт в обработку посреди правки.
+func lockEditableOffer(ctx context.Context, tx *sql.Tx, offerID int64) error {
+	var status int
+	err := tx.QueryRowContext(ctx, `
+		SELECT STATUS FROM OFFER WITH (UPDLOCK, ROWLOCK) WHERE ID_OFFER = @id
+	`, sql.Named("id", offerID)).Scan(&status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("offer with id %d not found", offerID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock offer: %w", err)
+	}
+
+	if !models.OfferItemsEditable(status) {
+		return &models.OfferStateError{OfferID: offerID, Status: status, Action: "edit items"}
+	}
+	return nil
+}
+
+// lockEditableOfferByItem — то же, что lockEditableOffer, для заявки, которой принадлежит позиция.
+// Дополнительно проверяет, что перемещение получателю позиции ещё не сохранено. Возвращает ID заявки.
+func lockEditableOfferByItem(ctx context.Context, tx *sql.Tx, itemID int64) (int64, error) {
+	var offerID int64
+	var contractorTo string
+	err := tx.QueryRowContext(ctx, `
+		SELECT ID_OFFER, CAST(ID_CONTRACTOR_GLOBAL_TO AS VARCHAR(36)) FROM OFFER_ITEM WHERE ID_OFFER_ITEM = @id
+	`, sql.Named("id", itemID)).Scan(&offerID, &contractorTo)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("offer item with id %d not found", itemID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get offer item: %w", err)
+	}
+
+	if err := lockEditableOffer(ctx, tx, offerID); err != nil {
+		return 0, err
+	}
+
+	saved, err := savedReceivers(ctx, tx, offerID)
+	if err != nil {
+		return 0, err
+	}
+	if saved[strings.ToUpper(contractorTo)] {
+		return 0, &models.ReceiverSavedError{OfferID: offerID, ContractorTo: contractorTo}
+	}
+	return offerID, nil
+}
+
+// offerSender возвращает отправителя заявки; вызывается после lockEditableOffer
+func offerSender(ctx context.Context, tx *sql.Tx, offerID int64) (string, error) {
+	var sender string
+	err := tx.QueryRowContext(ctx, `
+		SELECT CAST(ID_CONTRACTOR_GLOBAL_FROM AS VARCHAR(36)) FROM OFFER WHERE ID_OFFER = @id
+	`, sql.Named("id", offerID)).Scan(&sender)
+	if err != nil {
+		return "", fmt.Errorf("failed to get offer sender: %w", err)
+	}
+	return sender, nil
+}
+
+// checkItemOwnership проверяет, что отправитель каждой позиции — отправитель её заявки (senders),
+// а партия лежит на его складах. Иначе перемещение разошлось бы с шапкой, а позиция заняла бы
+// резерв чужой партии: резервы считаются по партии, а не по отправителю.
+// Непрошедшие позиции возвращаются как *models.OfferValidationError.
+func checkItemOwnership(ctx context.Context, tx *sql.Tx, items []models.OfferItem, senders map[int64]string) error {
+	var offerID int64
+	problems := []models.OfferItemProblem{}
+	for _, item := range items {
+		sender := senders[item.OfferID]
+
+		var itemProblems []string
+		if !strings.EqualFold(item.IdContractorGlobalFrom, sender) {
+			itemProblems = append(itemProblems, fmt.Sprintf("item sender differs from the offer sender %s", sender))
+		}
+
+		var held bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT CASE WHEN EXISTS (
+				SELECT 1 FROM LOT l
+				INNER JOIN STORE st ON st.ID_STORE = l.ID_STORE
+				INNER JOIN CONTRACTOR c ON c.ID_CONTRACTOR = st.ID_CONTRACTOR
+				WHERE l.ID_LOT_GLOBAL = @lot AND c.ID_CONTRACTOR_GLOBAL = @sender
+			) THEN 1 ELSE 0 END
+		`, sql.Named("lot", item.IdLotGlobal), sql.Named("sender", sender)).Scan(&held)
+		if err != nil {
+			return fmt.Errorf("failed to check lot %s: %w", item.IdLotGlobal, err)
+		}
+		if !held {
+			itemProblems = append(itemProblems, fmt.Sprintf("lot is not held by the offer sender %s", sender))
+		}
+		if len(itemProblems) == 0 {
+			continue
+		}
+
+		if len(problems) == 0 {
+			offerID = item.OfferID
+		}
+		problems = append(problems, models.OfferItemProblem{
+			ContractorFrom: item.IdContractorGlobalFrom,
+			ContractorTo:   item.IdContractorGlobalTo,
+			LotGlobal:      item.IdLotGlobal,
+			Quantity:       item.Quantity,
+			Problems:       itemProblems,
+		})
+	}
+
+	if len(problems) > 0 {
+		return &models.OfferValidationError{OfferID: offerID, Problems: problems}
+	}
+	return nil
 }
 
-// SaveInterfirmMoving вызывает USP_INTERFIRM_MOVING_SAVE с XML
-func (r *OfferRepository) SaveInterfirmMoving(ctx context.Context, xmlData string) error {
+// lockLotReservations блокирует позиции заявок по партиям до конца транзакции tx (UPDLOCK, HOLDLOCK —
+// включая диапазон ключей, чтобы параллельная заявка не вставила позицию по той же партии).
+// Партии блокируются в порядке GUID, чтобы две транзакции не ждали друг друга.
+func lockLotReservations(ctx context.Context, tx *sql.Tx, lots []string) error {
+	unique := make([]string, 0, len(lots))
+	seen := make(map[string]bool)
+	for _, lot := range lots {
+		key := strings.ToUpper(lot)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	sort.Strings(unique)
+
+	for _, lot := range unique {
+		var count int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM OFFER_ITEM WITH (UPDLOCK, HOLDLOCK) WHERE ID_LOT_GLOBAL = @lot
+		`, sql.Named("lot", lot)).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to lock reservations of lot %s: %w", lot, err)
+		}
+	}
+	return nil
+}
+
+// lotAllocation возвращает остаток партии, резерв других заявок и количество, запрошенное заявкой offerID
+func lotAllocation(ctx context.Context, tx *sql.Tx, offerID int64, lot string) (*models.LotAllocation, error) {
+	alloc := models.LotAllocation{LotGlobal: lot}
+	var reserved float64
+	err := tx.QueryRowContext(ctx, `
+		SELECT
+			ISNULL((SELECT SUM(QUANTITY_REM) FROM LOT WHERE ID_LOT_GLOBAL = @lot), 0),
+			ISNULL((SELECT QUANTITY_RESERVED FROM V_LOT_RESERVATION WHERE ID_LOT_GLOBAL = @lot), 0),
+			ISNULL((
+				SELECT SUM(oi.QUANTITY) FROM OFFER_ITEM oi
+				WHERE oi.ID_OFFER = @offer_id AND oi.ID_LOT_GLOBAL = @lot
+				  AND NOT EXISTS (
+					SELECT 1 FROM OFFER_MOVING om
+					WHERE om.ID_OFFER = oi.ID_OFFER AND om.ID_CONTRACTOR_GLOBAL_TO = oi.ID_CONTRACTOR_GLOBAL_TO
+				  )
+			), 0)
+	`, sql.Named("lot", lot), sql.Named("offer_id", offerID)).Scan(&alloc.Remaining, &reserved, &alloc.Requested)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check remains of lot %s: %w", lot, err)
+	}
+
+	// Заявка offerID редактируется, значит её позиции уже входят в резерв
+	// (кроме получателей с сохранённым перемещением — их нет ни в резерве, ни в Requested)
+	alloc.ReservedByOthers = reserved - alloc.Requested
+	alloc.Available = alloc.Remaining - alloc.ReservedByOthers
+	if alloc.Available < 0 {
+		alloc.Available = 0
+	}
+	return &alloc, nil
+}
+
+// ChangeOfferStatus переводит заявку из статуса change.From в change.To и пишет запись
+// в OFFER_STATUS_HISTORY в той же транзакции. Обновление условное: если статус успел
+// измениться, возвращается *models.OfferStateError. Допустимость перехода проверяет OfferService.
+func (r *OfferRepository) ChangeOfferStatus(ctx context.Context, change *models.OfferStatusChange) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := changeOfferStatus(ctx, tx, change); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// SaveReceiverMoving сохраняет перемещение одному получателю, его связь с заявкой (OFFER_MOVING)
+// и итог "saved" в OFFER_RECEIVER_OUTCOME одной транзакцией. Получатели сохраняются независимо,
+// поэтому после сбоя повторная обработка берёт только тех, у кого перемещения ещё нет.
+// Строка заявки блокируется на время транзакции; отработанная или удалённая заявка даёт *models.OfferStateError.
+// Уникальность OFFER_MOVING не даёт создать второе перемещение тому же получателю:
+// если его успела сохранить параллельная обработка, возвращается ErrReceiverAlreadySaved.
+func (r *OfferRepository) SaveReceiverMoving(ctx context.Context, offerID int64, m models.InterfirmMovingDoc, userName string) error {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
 	defer cancel()
 
-	_, err := r.db.ExecContext(ctx, `
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var status int
+	err = tx.QueryRowContext(ctx, `
+		SELECT STATUS FROM OFFER WITH (UPDLOCK, ROWLOCK) WHERE ID_OFFER = @id
+	`, sql.Named("id", offerID)).Scan(&status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("offer with id %d not found", offerID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock offer: %w", err)
+	}
+	if !models.CanTransitionOffer(status, models.OfferStatusProcessed) {
+		return &models.OfferStateError{OfferID: offerID, Status: status, Action: "process"}
+	}
+
+	var linked int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM OFFER_MOVING WHERE ID_OFFER = @offer_id AND ID_CONTRACTOR_GLOBAL_TO = @to_id
+	`, sql.Named("offer_id", offerID), sql.Named("to_id", m.ContractorTo)).Scan(&linked)
+	if err != nil {
+		return fmt.Errorf("failed to check interfirm moving for %s: %w", m.ContractorTo, err)
+	}
+	if linked > 0 {
+		return ErrReceiverAlreadySaved
+	}
+
+	_, err = tx.ExecContext(ctx, `
 		EXEC USP_INTERFIRM_MOVING_SAVE @XML_DATA = @xml
-	`, sql.Named("xml", xmlData))
+	`, sql.Named("xml", m.XML))
+	if err != nil {
+		return fmt.Errorf("failed to save interfirm moving for %s: %w", m.ContractorTo, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO OFFER_MOVING (ID_OFFER, ID_CONTRACTOR_GLOBAL_TO, ID_INTERFIRM_MOVING_GLOBAL, ITEM_COUNT, CREATED_BY)
+		VALUES (@offer_id, @to_id, @moving_global, @item_count, @user)
+	`,
+		sql.Named("offer_id", offerID),
+		sql.Named("to_id", m.ContractorTo),
+		sql.Named("moving_global", m.MovingGlobal),
+		sql.Named("item_count", m.ItemCount),
+		sql.Named("user", userName),
+	)
+	if isUniqueViolation(err) {
+		return ErrReceiverAlreadySaved
+	}
+	if err != nil {
+		return fmt.Errorf("failed to link interfirm moving for %s: %w", m.ContractorTo, err)
+	}
+
+	if err := saveReceiverOutcome(ctx, tx, offerID, m, models.ReceiverOutcomeSaved, "", userName); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ErrReceiverAlreadySaved — у получателя уже есть перемещение по этой заявке
+var ErrReceiverAlreadySaved = errors.New("interfirm moving for the receiver is already saved")
+
+// RecordReceiverFailure записывает неудачную попытку по получателю; вызывается вне откаченной транзакции
+func (r *OfferRepository) RecordReceiverFailure(ctx context.Context, offerID int64, m models.InterfirmMovingDoc, cause, userName string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to save interfirm moving: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := saveReceiverOutcome(ctx, tx, offerID, m, models.ReceiverOutcomeFailed, cause, userName); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
+// saveReceiverOutcome обновляет итог по получателю или добавляет его; ATTEMPTS растёт с каждой попыткой
+func saveReceiverOutcome(ctx context.Context, tx *sql.Tx, offerID int64, m models.InterfirmMovingDoc, status, cause, userName string) error {
+	movingGlobal := sql.NullString{String: m.MovingGlobal, Valid: status == models.ReceiverOutcomeSaved}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE OFFER_RECEIVER_OUTCOME WITH (UPDLOCK, SERIALIZABLE)
+		SET STATUS = @status,
+		    ID_INTERFIRM_MOVING_GLOBAL = @moving_global,
+		    ITEM_COUNT = @item_count,
+		    ATTEMPTS = ATTEMPTS + 1,
+		    ERROR_MESSAGE = NULLIF(@error, ''),
+		    USER_NAME = @user,
+		    UPDATED_AT = GETDATE()
+		WHERE ID_OFFER = @offer_id AND ID_CONTRACTOR_GLOBAL_TO = @to_id
+
+		IF @@ROWCOUNT = 0
+			INSERT INTO OFFER_RECEIVER_OUTCOME
+				(ID_OFFER, ID_CONTRACTOR_GLOBAL_TO, STATUS, ID_INTERFIRM_MOVING_GLOBAL, ITEM_COUNT, ERROR_MESSAGE, USER_NAME)
+			VALUES (@offer_id, @to_id, @status, @moving_global, @item_count, NULLIF(@error, ''), @user)
+	`,
+		sql.Named("offer_id", offerID),
+		sql.Named("to_id", m.ContractorTo),
+		sql.Named("status", status),
+		sql.Named("moving_global", movingGlobal),
+		sql.Named("item_count", m.ItemCount),
+		sql.Named("error", truncate(cause, 2000)),
+		sql.Named("user", truncate(userName, 100)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save receiver outcome for %s: %w", m.ContractorTo, err)
+	}
+	return nil
+}
+
+// GetSavedReceivers возвращает получателей заявки, у которых уже есть перемещение
+func (r *OfferRepository) GetSavedReceivers(ctx context.Context, offerID int64) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	return savedReceivers(ctx, r.db, offerID)
+}
+
+// savedReceivers возвращает получателей заявки с перемещением (GUID в верхнем регистре).
+// В транзакции вызывается под блокировкой строки заявки: SaveReceiverMoving берёт ту же блокировку.
+func savedReceivers(ctx context.Context, q rowsQuerier, offerID int64) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT CAST(ID_CONTRACTOR_GLOBAL_TO AS VARCHAR(36)) FROM OFFER_MOVING WHERE ID_OFFER = @id
+	`, sql.Named("id", offerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query saved receivers: %w", err)
+	}
+	defer rows.Close()
+
+	saved := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan saved receiver: %w", err)
+		}
+		saved[strings.ToUpper(id)] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return saved, nil
+}
+
+// GetReceiverOutcomes возвращает итоги обработки заявки по получателям
+func (r *OfferRepository) GetReceiverOutcomes(ctx context.Context, offerID int64) ([]models.ReceiverOutcome, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT
+			o.ID_OFFER,
+			CAST(o.ID_CONTRACTOR_GLOBAL_TO AS VARCHAR(36)),
+			ISNULL(c.NAME, ''),
+			o.STATUS,
+			ISNULL(CAST(o.ID_INTERFIRM_MOVING_GLOBAL AS VARCHAR(36)), ''),
+			o.ITEM_COUNT,
+			o.ATTEMPTS,
+			ISNULL(o.ERROR_MESSAGE, ''),
+			o.USER_NAME,
+			o.UPDATED_AT
+		FROM OFFER_RECEIVER_OUTCOME o
+		LEFT JOIN CONTRACTOR c ON c.ID_CONTRACTOR_GLOBAL = o.ID_CONTRACTOR_GLOBAL_TO
+		WHERE o.ID_OFFER = @id
+		ORDER BY o.ID_OFFER_RECEIVER_OUTCOME
+	`, sql.Named("id", offerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query receiver outcomes: %w", err)
+	}
+	defer rows.Close()
+
+	outcomes := []models.ReceiverOutcome{}
+	for rows.Next() {
+		var o models.ReceiverOutcome
+		if err := rows.Scan(&o.OfferID, &o.ContractorTo, &o.ContractorName, &o.Status, &o.MovingGlobal,
+			&o.ItemCount, &o.Attempts, &o.Error, &o.User, &o.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan receiver outcome: %w", err)
+		}
+		outcomes = append(outcomes, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return outcomes, nil
+}
+
+// changeOfferStatus — условная смена статуса с записью в историю внутри транзакции tx
+func changeOfferStatus(ctx context.Context, tx *sql.Tx, change *models.OfferStatusChange) error {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE OFFER 
+		SET STATUS = @to 
+		WHERE ID_OFFER = @id AND STATUS = @from`,
+		sql.Named("to", change.To),
+		sql.Named("from", change.From),
+		sql.Named("id", change.OfferID),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update offer status: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		var status int
+		err := tx.QueryRowContext(ctx, `
+			SELECT STATUS FROM OFFER WHERE ID_OFFER = @id
+		`, sql.Named("id", change.OfferID)).Scan(&status)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("offer with id %d not found", change.OfferID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get offer status: %w", err)
+		}
+		return &models.OfferStateError{OfferID: change.OfferID, Status: status, Action: "change status to " + models.OfferStatusName(change.To)}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO OFFER_STATUS_HISTORY (ID_OFFER, STATUS_FROM, STATUS_TO, USER_NAME, COMMENT, ERROR_MESSAGE)
+		VALUES (@id, @from, @to, @user, NULLIF(@comment, ''), NULLIF(@error, ''))`,
+		sql.Named("id", change.OfferID),
+		sql.Named("from", change.From),
+		sql.Named("to", change.To),
+		sql.Named("user", change.User),
+		sql.Named("comment", truncate(change.Comment, 500)),
+		sql.Named("error", truncate(change.Error, 2000)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write offer status history: %w", err)
+	}
+
+	return nil
+}
+
+// GetOfferMovings возвращает перемещения, созданные по заявке
+func (r *OfferRepository) GetOfferMovings(ctx context.Context, offerID int64) ([]models.OfferMoving, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT
+			om.ID_OFFER,
+			CAST(om.ID_CONTRACTOR_GLOBAL_TO AS VARCHAR(36)),
+			ISNULL(c.NAME, ''),
+			CAST(om.ID_INTERFIRM_MOVING_GLOBAL AS VARCHAR(36)),
+			ISNULL(im.MNEMOCODE, ''),
+			om.ITEM_COUNT,
+			om.CREATED_BY,
+			om.CREATED_AT
+		FROM OFFER_MOVING om
+		LEFT JOIN CONTRACTOR c ON c.ID_CONTRACTOR_GLOBAL = om.ID_CONTRACTOR_GLOBAL_TO
+		LEFT JOIN INTERFIRM_MOVING im ON im.ID_INTERFIRM_MOVING_GLOBAL = om.ID_INTERFIRM_MOVING_GLOBAL
+		WHERE om.ID_OFFER = @id
+		ORDER BY om.ID_OFFER_MOVING
+	`, sql.Named("id", offerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query offer movings: %w", err)
+	}
+	defer rows.Close()
+
+	movings := []models.OfferMoving{}
+	for rows.Next() {
+		var m models.OfferMoving
+		if err := rows.Scan(&m.OfferID, &m.ContractorTo, &m.ContractorName, &m.MovingGlobal,
+			&m.Mnemocode, &m.ItemCount, &m.CreatedBy, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan offer moving: %w", err)
+		}
+		movings = append(movings, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return movings, nil
+}
+
+// SaveOfferDocuments сохраняет XML перемещений попытки обработки заявки.
+// Вызывается до SaveReceiverMoving в отдельной транзакции, чтобы XML остался и при откате.
+func (r *OfferRepository) SaveOfferDocuments(ctx context.Context, offerID int64, userName string, docs []models.InterfirmMovingDoc) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, d := range docs {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO OFFER_DOCUMENT (ID_OFFER, ID_CONTRACTOR_GLOBAL_TO, ID_INTERFIRM_MOVING_GLOBAL, ITEM_COUNT, XML_DATA, CREATED_BY)
+			VALUES (@offer_id, @to_id, @moving_global, @item_count, @xml, @user)
+		`,
+			sql.Named("offer_id", offerID),
+			sql.Named("to_id", d.ContractorTo),
+			sql.Named("moving_global", d.MovingGlobal),
+			sql.Named("item_count", d.ItemCount),
+			sql.Named("xml", d.XML),
+			sql.Named("user", userName),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save offer document for %s: %w", d.ContractorTo, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetOfferDocuments возвращает сохранённые документы заявки от новых к старым, без XML.
+// latestOnly оставляет последнюю попытку по каждому получателю и заполняет XML.
+func (r *OfferRepository) GetOfferDocuments(ctx context.Context, offerID int64, latestOnly bool) ([]models.OfferDocument, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT ID_OFFER_DOCUMENT, ID_OFFER, ID_CONTRACTOR_GLOBAL_TO, CONTRACTOR_NAME, ID_INTERFIRM_MOVING_GLOBAL,
+		       ITEM_COUNT, SAVED, CREATED_BY, CREATED_AT, CASE WHEN @latest = 1 THEN XML_DATA ELSE '' END
+		FROM (
+			SELECT
+				od.ID_OFFER_DOCUMENT,
+				od.ID_OFFER,
+				CAST(od.ID_CONTRACTOR_GLOBAL_TO AS VARCHAR(36)) AS ID_CONTRACTOR_GLOBAL_TO,
+				ISNULL(c.NAME, '') AS CONTRACTOR_NAME,
+				CAST(od.ID_INTERFIRM_MOVING_GLOBAL AS VARCHAR(36)) AS ID_INTERFIRM_MOVING_GLOBAL,
+				od.ITEM_COUNT,
+				CASE WHEN om.ID_OFFER_MOVING IS NULL THEN 0 ELSE 1 END AS SAVED,
+				od.CREATED_BY,
+				od.CREATED_AT,
+				od.XML_DATA,
+				ROW_NUMBER() OVER (PARTITION BY od.ID_CONTRACTOR_GLOBAL_TO ORDER BY od.ID_OFFER_DOCUMENT DESC) AS RN
+			FROM OFFER_DOCUMENT od
+			LEFT JOIN CONTRACTOR c ON c.ID_CONTRACTOR_GLOBAL = od.ID_CONTRACTOR_GLOBAL_TO
+			LEFT JOIN OFFER_MOVING om ON om.ID_INTERFIRM_MOVING_GLOBAL = od.ID_INTERFIRM_MOVING_GLOBAL
+			WHERE od.ID_OFFER = @id
+		) d
+		WHERE @latest = 0 OR RN = 1
+		ORDER BY ID_OFFER_DOCUMENT DESC
+	`, sql.Named("id", offerID), sql.Named("latest", latestOnly))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query offer documents: %w", err)
+	}
+	defer rows.Close()
+
+	docs := []models.OfferDocument{}
+	for rows.Next() {
+		var d models.OfferDocument
+		if err := rows.Scan(&d.ID, &d.OfferID, &d.ContractorTo, &d.ContractorName, &d.MovingGlobal,
+			&d.ItemCount, &d.Saved, &d.CreatedBy, &d.CreatedAt, &d.XML); err != nil {
+			return nil, fmt.Errorf("failed to scan offer document: %w", err)
+		}
+		docs = append(docs, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return docs, nil
+}
+
+// GetOfferDocument возвращает документ заявки вместе с XML
+func (r *OfferRepository) GetOfferDocument(ctx context.Context, offerID, documentID int64) (*models.OfferDocument, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	var d models.OfferDocument
+	err := r.db.QueryRowContext(ctx, `
+		SELECT
+			od.ID_OFFER_DOCUMENT,
+			od.ID_OFFER,
+			CAST(od.ID_CONTRACTOR_GLOBAL_TO AS VARCHAR(36)),
+			ISNULL(c.NAME, ''),
+			CAST(od.ID_INTERFIRM_MOVING_GLOBAL AS VARCHAR(36)),
+			od.ITEM_COUNT,
+			CASE WHEN om.ID_OFFER_MOVING IS NULL THEN 0 ELSE 1 END,
+			od.CREATED_BY,
+			od.CREATED_AT,
+			od.XML_DATA
+		FROM OFFER_DOCUMENT od
+		LEFT JOIN CONTRACTOR c ON c.ID_CONTRACTOR_GLOBAL = od.ID_CONTRACTOR_GLOBAL_TO
+		LEFT JOIN OFFER_MOVING om ON om.ID_INTERFIRM_MOVING_GLOBAL = od.ID_INTERFIRM_MOVING_GLOBAL
+		WHERE od.ID_OFFER = @offer_id AND od.ID_OFFER_DOCUMENT = @id
+	`, sql.Named("offer_id", offerID), sql.Named("id", documentID)).Scan(
+		&d.ID, &d.OfferID, &d.ContractorTo, &d.ContractorName, &d.MovingGlobal,
+		&d.ItemCount, &d.Saved, &d.CreatedBy, &d.CreatedAt, &d.XML,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("offer document with id %d not found", documentID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offer document: %w", err)
+	}
+
+	return &d, nil
+}
+
+// GetOfferStatusHistory возвращает историю статусов заявки от старых записей к новым
+func (r *OfferRepository) GetOfferStatusHistory(ctx context.Context, offerID int64) ([]models.OfferStatusChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT ID_OFFER_STATUS_HISTORY, ID_OFFER, STATUS_FROM, STATUS_TO, USER_NAME, CHANGED_AT,
+		       ISNULL(COMMENT, ''), ISNULL(ERROR_MESSAGE, '')
+		FROM OFFER_STATUS_HISTORY
+		WHERE ID_OFFER = @id
+		ORDER BY CHANGED_AT, ID_OFFER_STATUS_HISTORY
+	`, sql.Named("id", offerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query offer status history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []models.OfferStatusChange{}
+	for rows.Next() {
+		var c models.OfferStatusChange
+		if err := rows.Scan(&c.ID, &c.OfferID, &c.From, &c.To, &c.User, &c.ChangedAt, &c.Comment, &c.Error); err != nil {
+			return nil, fmt.Errorf("failed to scan offer status history: %w", err)
+		}
+		history = append(history, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return history, nil
+}
+
+// ProcessOffer вызывает usp_GenerateInterfirmMovingFromOffer и возвращает сырые строки.
+// Строки с ненайденными складами или ценами возвращаются с заполненным Problems.
+func (r *OfferRepository) ProcessOffer(ctx context.Context, offerID int64) ([]models.InterfirmRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		EXEC usp_GenerateInterfirmMovingFromOffer @id_offer = @offer_id
+	`, sql.Named("offer_id", offerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute usp_GenerateInterfirmMovingFromOffer: %w", err)
+	}
+	defer rows.Close()
+
+	var results []models.InterfirmRow
+	for rows.Next() {
+		var row models.InterfirmRow
+		// Склады и цены партии могут не найтись — такие строки помечаются, а не ломают весь разбор
+		var storeFromMain, storeFromTransit, storeToMain, storeToTransit sql.NullInt64
+		var sumSupplier, sVatSupplier, pVatRetail, vatRetail sql.NullFloat64
+		err := rows.Scan(
+			&row.IDContractorGlobalTo,
+			&row.IDInterfirmMoving,
+			&row.IDInterfirmMovingGlobal,
+			&row.Mnemocode,
+			&storeFromMain,
+			&storeFromTransit,
+			&row.IDContractorTo,
+			&storeToMain,
+			&storeToTransit,
+			&row.Date,
+			&row.DocumentState,
+			&row.Comment,
+			&row.IDUser,
+			&row.IDUser2,
+			&row.SumSupplierHeader,
+			&row.SVatSupplierHeader,
+			&row.SumRetailHeader,
+			&row.SVatRetailHeader,
+			&row.GoodsSent,
+			&row.AuthNum,
+			&row.AuthValidPeriod,
+			&row.IDInterfirmMovingItem,
+			&row.IDInterfirmMovingItemGlobal,
+			&row.Quantity,
+			&row.IDLotFrom,
+			&row.IDLotTo,
+			&sumSupplier,
+			&sVatSupplier,
+			&pVatRetail,
+			&vatRetail,
+			&row.IsWeight,
+			&row.Kiz,   // ← NULL
+			&row.IsKiz, // ← 1 или 0
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+
+		row.IDStoreFromMain = requireInt64(&row, storeFromMain, "sender MAIN store not found")
+		row.IDStoreFromTransit = requireInt64(&row, storeFromTransit, "sender TRS store not found")
+		row.IDStoreToMain = requireInt64(&row, storeToMain, "receiver MAIN store not found")
+		row.IDStoreToTransit = requireInt64(&row, storeToTransit, "receiver TRR store not found")
+		row.SumSupplier = requireFloat64(&row, sumSupplier, "lot supplier price is not set")
+		row.SVatSupplier = requireFloat64(&row, sVatSupplier, "lot supplier VAT is not set")
+		row.PVatRetail = requireFloat64(&row, pVatRetail, "lot retail price is not set")
+		row.VatRetail = requireFloat64(&row, vatRetail, "lot retail VAT is not set")
+		if row.Quantity <= 0 {
+			row.Problems = append(row.Problems, "quantity must be greater than zero")
+		}
+
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return results, nil
+}
+
+// ValidateOfferItems проверяет позиции заявки до вызова usp_GenerateInterfirmMovingFromOffer:
+// у отправителя ровно один склад TRS, у получателя — по одному MAIN и TRR,
+// партия существует, лежит у отправителя и её остатка хватает на все позиции заявки.
+// Позиции получателей, которым перемещение уже сохранено, не проверяются.
+// Возвращает только позиции с проблемами.
+func (r *OfferRepository) ValidateOfferItems(ctx context.Context, offerID int64) ([]models.OfferItemProblem, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		WITH pending_items AS (
+			SELECT oi.*
+			FROM OFFER_ITEM oi
+			WHERE oi.ID_OFFER = @offer_id
+			  AND NOT EXISTS (
+				SELECT 1 FROM OFFER_MOVING om
+				WHERE om.ID_OFFER = oi.ID_OFFER AND om.ID_CONTRACTOR_GLOBAL_TO = oi.ID_CONTRACTOR_GLOBAL_TO
+			  )
+		),
+		offer_contractors AS (
+			SELECT ID_CONTRACTOR_GLOBAL_FROM AS ID_CONTRACTOR_GLOBAL FROM pending_items
+			UNION
+			SELECT ID_CONTRACTOR_GLOBAL_TO FROM pending_items
+		),
+		stores AS (
+			SELECT c.ID_CONTRACTOR_GLOBAL, st.MNEMOCODE AS TYPE_STORE, COUNT(*) AS CNT
+			FROM offer_contractors oc
+			INNER JOIN CONTRACTOR c ON c.ID_CONTRACTOR_GLOBAL = oc.ID_CONTRACTOR_GLOBAL
+			INNER JOIN STORE s ON s.ID_CONTRACTOR = c.ID_CONTRACTOR
+			INNER JOIN STORE_TYPE st ON st.ID_STORE_TYPE_GLOBAL = s.ID_STORE_TYPE_GLOBAL
+			GROUP BY c.ID_CONTRACTOR_GLOBAL, st.MNEMOCODE
+		),
+		lot_demand AS (
+			SELECT ID_LOT_GLOBAL, SUM(QUANTITY) AS QTY
+			FROM pending_items
+			GROUP BY ID_LOT_GLOBAL
+		)
+		SELECT
+			oi.ID_OFFER_ITEM,
+			ISNULL(g.NAME, ''),
+			CAST(oi.ID_CONTRACTOR_GLOBAL_FROM AS VARCHAR(36)),
+			CAST(oi.ID_CONTRACTOR_GLOBAL_TO AS VARCHAR(36)),
+			CAST(oi.ID_LOT_GLOBAL AS VARCHAR(36)),
+			oi.QUANTITY,
+			ISNULL(trs.CNT, 0),
+			ISNULL(mn.CNT, 0),
+			ISNULL(trr.CNT, 0),
+			CASE WHEN l.ID_LOT IS NULL THEN 0 ELSE 1 END,
+			ISNULL(l.QUANTITY_REM, 0),
+			ISNULL(ld.QTY, 0),
+			CASE WHEN lc.ID_CONTRACTOR_GLOBAL = oi.ID_CONTRACTOR_GLOBAL_FROM THEN 1 ELSE 0 END
+		FROM pending_items oi
+		LEFT JOIN GOODS g ON g.ID_GOODS_GLOBAL = oi.GOODS_ID
+		LEFT JOIN stores trs ON trs.ID_CONTRACTOR_GLOBAL = oi.ID_CONTRACTOR_GLOBAL_FROM AND trs.TYPE_STORE = 'TRS'
+		LEFT JOIN stores mn ON mn.ID_CONTRACTOR_GLOBAL = oi.ID_CONTRACTOR_GLOBAL_TO AND mn.TYPE_STORE = 'MAIN'
+		LEFT JOIN stores trr ON trr.ID_CONTRACTOR_GLOBAL = oi.ID_CONTRACTOR_GLOBAL_TO AND trr.TYPE_STORE = 'TRR'
+		LEFT JOIN LOT l ON l.ID_LOT_GLOBAL = oi.ID_LOT_GLOBAL
+		LEFT JOIN STORE ls ON ls.ID_STORE = l.ID_STORE
+		LEFT JOIN CONTRACTOR lc ON lc.ID_CONTRACTOR = ls.ID_CONTRACTOR
+		LEFT JOIN lot_demand ld ON ld.ID_LOT_GLOBAL = oi.ID_LOT_GLOBAL
+		ORDER BY oi.ID_OFFER_ITEM
+	`, sql.Named("offer_id", offerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate offer items: %w", err)
+	}
+	defer rows.Close()
+
+	problems := []models.OfferItemProblem{}
+	for rows.Next() {
+		var item models.OfferItemProblem
+		var trsCount, mainCount, trrCount, lotExists, lotOwned int
+		var remaining, demand float64
+		if err := rows.Scan(
+			&item.ItemID, &item.GoodsName, &item.ContractorFrom, &item.ContractorTo, &item.LotGlobal, &item.Quantity,
+			&trsCount, &mainCount, &trrCount, &lotExists, &remaining, &demand, &lotOwned,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan offer item check: %w", err)
+		}
+
+		item.Problems = append(item.Problems, storeProblems("sender", "TRS", trsCount)...)
+		item.Problems = append(item.Problems, storeProblems("receiver", "MAIN", mainCount)...)
+		item.Problems = append(item.Problems, storeProblems("receiver", "TRR", trrCount)...)
+
+		switch {
+		case lotExists == 0:
+			item.Problems = append(item.Problems, "lot no longer exists")
+		case lotOwned == 0:
+			item.Problems = append(item.Problems, "lot belongs to another pharmacy than the sender")
+		case remaining < demand:
+			item.Problems = append(item.Problems,
+				fmt.Sprintf("lot has %g left, offer requests %g", remaining, demand))
+		}
+		if item.Quantity <= 0 {
+			item.Problems = append(item.Problems, "quantity must be greater than zero")
+		}
+
+		if len(item.Problems) > 0 {
+			problems = append(problems, item)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return problems, nil
+}
+
+// storeProblems описывает отсутствующий или неоднозначный склад нужного типа
+func storeProblems(side, storeType string, count int) []string {
+	switch {
+	case count == 0:
+		return []string{fmt.Sprintf("%s has no %s store", side, storeType)}
+	case count > 1:
+		return []string{fmt.Sprintf("%s has %d %s stores, expected one", side, count, storeType)}
+	}
+	return nil
+}
+
+// requireInt64 возвращает значение или 0 с записью проблемы в строку
+func requireInt64(row *models.InterfirmRow, v sql.NullInt64, problem string) int64 {
+	if !v.Valid {
+		row.Problems = append(row.Problems, problem)
+	}
+	return v.Int64
+}
+
+// requireFloat64 возвращает значение или 0 с записью проблемы в строку
+func requireFloat64(row *models.InterfirmRow, v sql.NullFloat64, problem string) float64 {
+	if !v.Valid {
+		row.Problems = append(row.Problems, problem)
+	}
+	return v.Float64
+}
+
 // getContractorName получает имя контрагента по его ID_CONTRACTOR_GLOBAL
 func (r *OfferRepository) GetContractorName(ctx context.Context, id string) string {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
@@ -423,3 +1890,23 @@ func (r *OfferRepository) GetContractorName(ctx context.Context, id string) stri
 	}
 	return name
 }
+
+// namedInClause строит список параметров @prefix0, @prefix1, ... для IN (...)
+func namedInClause(prefix string, values []string) (string, []interface{}) {
+	names := make([]string, 0, len(values))
+	args := make([]interface{}, 0, len(values))
+	for i, v := range values {
+		name := fmt.Sprintf("%s%d", prefix, i)
+		names = append(names, "@"+name)
+		args = append(args, sql.Named(name, v))
+	}
+	return strings.Join(names, ", "), args
+}
+
+// likeEscaper экранирует символы шаблона LIKE; используется с ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `[`, `\[`)
+
+// escapeLike превращает пользовательский ввод в буквальную подстроку для LIKE ... ESCAPE '\'
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,449 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"RemainsManager/internal/models"
+)
+
+// stubOfferStore — подставная база для GetOrCreateTodayOffer: таблица OFFER в памяти
+// с уникальным индексом UX_OFFER_DEFAULT_PER_DAY. Блокировки UPDLOCK/HOLDLOCK она не
+// воспроизводит, поэтому от второй заявки защищает только индекс — как при сбое блокировки.
+// Первые selectBarrier чтений ждут друг друга, чтобы все вызовы начали с пустой таблицы.
+type stubOfferStore struct {
+	mu         sync.Mutex
+	changed    *sync.Cond
+	rows       []*stubOfferRow
+	nextID     int64
+	violations int
+
+	selectBarrier int
+	selects       int
+	barrierDone   chan struct{}
+}
+
+type stubOfferRow struct {
+	id        int64
+	name      string
+	fromID    string
+	createdAt time.Time
+	tx        *stubTx // nil — зафиксирована
+}
+
+// stubSQLError — ошибка сервера с номером, как у go-mssqldb
+type stubSQLError struct{ number int32 }
+
+func (e stubSQLError) Error() string         { return fmt.Sprintf("mssql: error %d", e.number) }
+func (e stubSQLError) SQLErrorNumber() int32 { return e.number }
+
+func newStubOfferStore(selectBarrier int) *stubOfferStore {
+	s := &stubOfferStore{selectBarrier: selectBarrier, barrierDone: make(chan struct{})}
+	s.changed = sync.NewCond(&s.mu)
+	return s
+}
+
+// selectDefault ищет зафиксированную заявку по умолчанию отправителя.
+// Результат снимается в момент вызова, до ожидания у барьера.
+func (s *stubOfferStore) selectDefault(fromID string) *stubOfferRow {
+	s.mu.Lock()
+	var found *stubOfferRow
+	for _, row := range s.rows {
+		if row.tx == nil && strings.EqualFold(row.fromID, fromID) {
+			found = row
+			break
+		}
+	}
+	s.selects++
+	if s.selects == s.selectBarrier {
+		close(s.barrierDone)
+	}
+	waitBarrier := s.selects <= s.selectBarrier
+	s.mu.Unlock()
+
+	if waitBarrier {
+		<-s.barrierDone
+	}
+	return found
+}
+
+// insertDefault вставляет заявку по умолчанию. Как SQL Server, вставка ждёт незафиксированную
+// строку с тем же ключом и получает ошибку 2627, если та зафиксирована.
+func (s *stubOfferStore) insertDefault(tx *stubTx, fromID, name string) (*stubOfferRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		var conflict *stubOfferRow
+		for _, row := range s.rows {
+			if strings.EqualFold(row.fromID, fromID) && row.tx != tx {
+				conflict = row
+				break
+			}
+		}
+		if conflict == nil {
+			break
+		}
+		if conflict.tx == nil {
+			s.violations++
+			return nil, stubSQLError{number: sqlErrUniqueConstraint}
+		}
+		s.changed.Wait()
+	}
+
+	s.nextID++
+	row := &stubOfferRow{id: s.nextID, name: name, fromID: fromID, createdAt: time.Now(), tx: tx}
+	s.rows = append(s.rows, row)
+	return row, nil
+}
+
+func (s *stubOfferStore) finish(tx *stubTx, commit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.rows[:0]
+	for _, row := range s.rows {
+		if row.tx == tx {
+			if !commit {
+				continue
+			}
+			row.tx = nil
+		}
+		kept = append(kept, row)
+	}
+	s.rows = kept
+	s.changed.Broadcast()
+}
+
+func (s *stubOfferStore) committedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, row := range s.rows {
+		if row.tx == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Драйвер database/sql поверх stubOfferStore
+
+type stubConnector struct{ store *stubOfferStore }
+
+func (c *stubConnector) Connect(context.Context) (driver.Conn, error) {
+	return &stubConn{store: c.store}, nil
+}
+
+func (c *stubConnector) Driver() driver.Driver { return stubDriver{} }
+
+type stubDriver struct{}
+
+func (stubDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("use sql.OpenDB with stubConnector")
+}
+
+type stubConn struct {
+	store *stubOfferStore
+	tx    *stubTx
+}
+
+type stubTx struct{ conn *stubConn }
+
+func (t *stubTx) Commit() error {
+	t.conn.store.finish(t, true)
+	t.conn.tx = nil
+	return nil
+}
+
+func (t *stubTx) Rollback() error {
+	t.conn.store.finish(t, false)
+	t.conn.tx = nil
+	return nil
+}
+
+func (c *stubConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *stubConn) Close() error { return nil }
+
+func (c *stubConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *stubConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.tx = &stubTx{conn: c}
+	return c.tx, nil
+}
+
+func (c *stubConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	arg := func(name string) string {
+		for _, a := range args {
+			if a.Name == name {
+				return fmt.Sprint(a.Value)
+			}
+		}
+		return ""
+	}
+
+	switch {
+	case strings.Contains(query, "FROM OFFER WITH (UPDLOCK, HOLDLOCK)"):
+		columns := []string{"ID_OFFER", "NAME", "ID_CONTRACTOR_GLOBAL_FROM", "CREATED_AT", "STATUS", "IS_DEFAULT"}
+		row := c.store.selectDefault(arg("from_id"))
+		if row == nil {
+			return &stubRows{columns: columns}, nil
+		}
+		return &stubRows{columns: columns, values: [][]driver.Value{
+			{row.id, row.name, row.fromID, row.createdAt, int64(0), true},
+		}}, nil
+
+	case strings.Contains(query, "INSERT INTO OFFER ("):
+		row, err := c.store.insertDefault(c.tx, arg("from_id"), arg("name"))
+		if err != nil {
+			return nil, err
+		}
+		return &stubRows{
+			columns: []string{"ID_OFFER", "CREATED_AT"},
+			values:  [][]driver.Value{{row.id, row.createdAt}},
+		}, nil
+
+	case strings.Contains(query, "FROM OFFER_ITEM"):
+		return &stubRows{columns: []string{"ID_OFFER_ITEM", "ID_CONTRACTOR_GLOBAL_FROM", "ID_CONTRACTOR_GLOBAL_TO", "GOODS_ID", "QUANTITY"}}, nil
+	}
+	return nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+type stubRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestGetOrCreateTodayOfferConcurrent(t *testing.T) {
+	const n = 20
+	const fromID = "6F9619FF-8B86-D011-B42D-00C04FC964FF"
+
+	store := newStubOfferStore(n)
+	db := sql.OpenDB(&stubConnector{store: store})
+	defer db.Close()
+	repo := NewOfferRepository(5, db)
+
+	ids := make([]int64, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			offer, err := repo.GetOrCreateTodayOffer(context.Background(), fromID, "Аптека 1")
+			errs[i] = err
+			if err == nil {
+				ids[i] = offer.ID
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("call %d: GetOrCreateTodayOffer: %v", i, err)
+		}
+	}
+	for i, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("call %d returned offer %d, call 0 returned %d; want one offer for all", i, id, ids[0])
+		}
+	}
+	if got := store.committedCount(); got != 1 {
+		t.Fatalf("store holds %d offers; want exactly 1", got)
+	}
+	// Все, кроме создавшего, упираются в уникальный индекс и перечитывают заявку
+	if store.violations != n-1 {
+		t.Fatalf("%d calls hit the unique index; want %d to go through the retry path", store.violations, n-1)
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{stubSQLError{number: sqlErrUniqueConstraint}, true},
+		{stubSQLError{number: sqlErrDuplicateKey}, true},
+		{fmt.Errorf("failed to create offer: %w", stubSQLError{number: sqlErrUniqueConstraint}), true},
+		{stubSQLError{number: sqlErrDeadlock}, false},
+		{errors.New("connection reset"), false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := isUniqueViolation(c.err); got != c.want {
+			t.Errorf("isUniqueViolation(%v) = %v; want %v", c.err, got, c.want)
+		}
+	}
+}
+
+// scriptedConnector — подставная база, отвечающая на запросы функцией respond.
+// Транзакции ничего не делают; любая запись в базу (ExecContext) — ошибка теста.
+type scriptedConnector struct {
+	respond func(query string, args map[string]string) (*stubRows, error)
+}
+
+func (c *scriptedConnector) Connect(context.Context) (driver.Conn, error) {
+	return &scriptedConn{respond: c.respond}, nil
+}
+
+func (c *scriptedConnector) Driver() driver.Driver { return stubDriver{} }
+
+type scriptedConn struct {
+	respond func(query string, args map[string]string) (*stubRows, error)
+}
+
+func (c *scriptedConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *scriptedConn) Close() error                             { return nil }
+func (c *scriptedConn) Begin() (driver.Tx, error)                { return scriptedTx{}, nil }
+func (c *scriptedConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+func (c *scriptedConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return scriptedTx{}, nil
+}
+
+func (c *scriptedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	named := make(map[string]string, len(args))
+	for _, a := range args {
+		named[a.Name] = fmt.Sprint(a.Value)
+	}
+	return c.respond(query, named)
+}
+
+func (c *scriptedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return nil, fmt.Errorf("unexpected write: %s", query)
+}
+
+type scriptedTx struct{}
+
+func (scriptedTx) Commit() error   { return nil }
+func (scriptedTx) Rollback() error { return nil }
+
+// addItemsStore отвечает на запросы AddItems: заявка senderID в статусе "новая",
+// без сохранённых перемещений, партии из lots лежат на складах senderID
+func addItemsStore(senderID string, lots ...string) func(string, map[string]string) (*stubRows, error) {
+	held := make(map[string]bool)
+	for _, lot := range lots {
+		held[strings.ToUpper(lot)] = true
+	}
+	return func(query string, args map[string]string) (*stubRows, error) {
+		switch {
+		case strings.Contains(query, "SELECT STATUS FROM OFFER WITH (UPDLOCK, ROWLOCK)"):
+			return &stubRows{columns: []string{"STATUS"}, values: [][]driver.Value{{int64(0)}}}, nil
+		case strings.Contains(query, "FROM OFFER_MOVING"):
+			return &stubRows{columns: []string{"ID_CONTRACTOR_GLOBAL_TO"}}, nil
+		case strings.Contains(query, "SELECT CAST(ID_CONTRACTOR_GLOBAL_FROM AS VARCHAR(36)) FROM OFFER"):
+			return &stubRows{columns: []string{"ID_CONTRACTOR_GLOBAL_FROM"}, values: [][]driver.Value{{senderID}}}, nil
+		case strings.Contains(query, "FROM LOT l"):
+			ok := int64(0)
+			if strings.EqualFold(args["sender"], senderID) && held[strings.ToUpper(args["lot"])] {
+				ok = 1
+			}
+			return &stubRows{columns: []string{"HELD"}, values: [][]driver.Value{{ok}}}, nil
+		}
+		return nil, fmt.Errorf("unexpected query: %s", query)
+	}
+}
+
+func TestAddItemsRejectsLotOfAnotherPharmacy(t *testing.T) {
+	const (
+		pharmacyA = "6F9619FF-8B86-D011-B42D-00C04FC964FF"
+		pharmacyB = "0B5E6B3C-3C62-4E6A-9D3E-2F4C1A7B8D90"
+		lotOfA    = "A1F0C2D3-0000-0000-0000-00000000000A"
+		lotOfC    = "C1F0C2D3-0000-0000-0000-00000000000C"
+	)
+
+	db := sql.OpenDB(&scriptedConnector{respond: addItemsStore(pharmacyA, lotOfA)})
+	defer db.Close()
+	repo := NewOfferRepository(5, db)
+
+	err := repo.AddItems(context.Background(), []models.OfferItem{
+		{OfferID: 7, IdContractorGlobalFrom: pharmacyA, IdContractorGlobalTo: pharmacyB, GoodsId: "G1", Quantity: 1, IdLotGlobal: lotOfA},
+		{OfferID: 7, IdContractorGlobalFrom: pharmacyA, IdContractorGlobalTo: pharmacyB, GoodsId: "G2", Quantity: 40, IdLotGlobal: lotOfC},
+	})
+
+	var validationErr *models.OfferValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("AddItems error = %v; want *models.OfferValidationError", err)
+	}
+	if validationErr.OfferID != 7 {
+		t.Errorf("OfferID = %d; want 7", validationErr.OfferID)
+	}
+	if len(validationErr.Problems) != 1 || validationErr.Problems[0].LotGlobal != lotOfC {
+		t.Fatalf("problems = %+v; want only the line with lot %s", validationErr.Problems, lotOfC)
+	}
+}
+
+func TestAddItemsRejectsLineFromAnotherSender(t *testing.T) {
+	const (
+		pharmacyA = "6F9619FF-8B86-D011-B42D-00C04FC964FF"
+		pharmacyB = "0B5E6B3C-3C62-4E6A-9D3E-2F4C1A7B8D90"
+		pharmacyR = "5D7C9E1A-2B4F-4C6D-8E0A-1B3C5D7E9F01"
+		lotOfA    = "A1F0C2D3-0000-0000-0000-00000000000A"
+	)
+
+	db := sql.OpenDB(&scriptedConnector{respond: addItemsStore(pharmacyA, lotOfA)})
+	defer db.Close()
+	repo := NewOfferRepository(5, db)
+
+	// Пользователь с доступом к A и B кладёт в заявку A строку "от B"
+	err := repo.AddItems(context.Background(), []models.OfferItem{
+		{OfferID: 7, IdContractorGlobalFrom: pharmacyB, IdContractorGlobalTo: pharmacyR, GoodsId: "G1", Quantity: 1, IdLotGlobal: lotOfA},
+	})
+
+	var validationErr *models.OfferValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("AddItems error = %v; want *models.OfferValidationError", err)
+	}
+	if len(validationErr.Problems) != 1 || validationErr.Problems[0].ContractorFrom != pharmacyB {
+		t.Fatalf("problems = %+v; want the line from %s", validationErr.Problems, pharmacyB)
+	}
+	if got := validationErr.Problems[0].Problems; len(got) != 1 || !strings.Contains(got[0], "sender") {
+		t.Errorf("line problems = %q; want only the sender mismatch", got)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	cases := map[string]string{
+		"Аптека 1": "Аптека 1",
+		"50%":      `50\%`,
+		"A_1":      `A\_1`,
+		"[x]":      `\[x]`,
+		`C:\tmp`:   `C:\\tmp`,
+	}
+	for in, want := range cases {
+		if got := escapeLike(in); got != want {
+			t.Errorf("escapeLike(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
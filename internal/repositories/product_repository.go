@@ -58,7 +58,7 @@ func (r *ProductRepository) GetInactiveStockProducts(contractGlobalID string, da
 	var products []models.InactiveStockProduct
 	for rows.Next() {
 		var p models.InactiveStockProduct
-		err := rows.Scan(&p.IdLotGlobal, &p.LotName, &p.Name, &p.Qty, &p.PriceSal, &p.PriceProd, &p.DaysNoMovement, &p.BestBefore, &p.IdGoodsGlobal, &p.NoMovement, &p.InternalBarcode)
+		err := rows.Scan(&p.IdLotGlobal, &p.LotName, &p.Name, &p.Qty, &p.PriceSal, &p.PriceProd, &p.DaysNoMovement, &p.BestBefore, &p.IdGoodsGlobal, &p.NoMovement, &p.InternalBarcode, &p.QtyReserved, &p.QtyAvailable)
 		if err != nil {
 			return nil, 0, fmt.Errorf("error scanning row: %w", err)
 		}
@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Номера ошибок SQL Server, которые обрабатываются отдельно
+const (
+	sqlErrDuplicateKey     = 2601 // нарушение уникального индекса
+	sqlErrUniqueConstraint = 2627 // нарушение UNIQUE / PRIMARY KEY
+	sqlErrDeadlock         = 1205 // транзакция выбрана жертвой взаимоблокировки
+	sqlErrLockTimeout      = 1222 // истекло ожидание блокировки
+)
+
+// sqlErrorNumber возвращает номер ошибки SQL Server или 0, если ошибка не от сервера.
+// Драйвер не импортируется: его ошибка реализует SQLErrorNumber.
+func sqlErrorNumber(err error) int32 {
+	var sqlErr interface{ SQLErrorNumber() int32 }
+	if errors.As(err, &sqlErr) {
+		return sqlErr.SQLErrorNumber()
+	}
+	return 0
+}
+
+// isUniqueViolation — вставка нарушила уникальный индекс или ограничение
+func isUniqueViolation(err error) bool {
+	n := sqlErrorNumber(err)
+	return n == sqlErrDuplicateKey || n == sqlErrUniqueConstraint
+}
+
+// IsTransient — ошибка, после которой операцию стоит повторить: взаимоблокировка (1205),
+// истечение ожидания блокировки (1222) или таймаут запроса/соединения
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch sqlErrorNumber(err) {
+	case sqlErrDeadlock, sqlErrLockTimeout:
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
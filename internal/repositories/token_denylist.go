@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTokenAlreadyRevoked — jti уже в списке отозванных: токен использован или отозван раньше
+var ErrTokenAlreadyRevoked = errors.New("token is already revoked")
+
+// TokenDenylist хранит идентификаторы (jti) отозванных токенов.
+// Запись нужна только до истечения срока действия токена.
+// Revoke атомарен: из нескольких одновременных вызовов с одним jti успешен ровно один,
+// остальные получают ErrTokenAlreadyRevoked.
+type TokenDenylist interface {
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// MemoryTokenDenylist — реализация в памяти процесса.
+// Подходит для одного экземпляра сервера; после перезапуска список пуст.
+type MemoryTokenDenylist struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+func NewMemoryTokenDenylist() *MemoryTokenDenylist {
+	return &MemoryTokenDenylist{revoked: make(map[string]time.Time)}
+}
+
+func (d *MemoryTokenDenylist) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range d.revoked {
+		if exp.Before(now) {
+			delete(d.revoked, id)
+		}
+	}
+	if _, ok := d.revoked[jti]; ok {
+		return ErrTokenAlreadyRevoked
+	}
+	d.revoked[jti] = expiresAt
+	return nil
+}
+
+func (d *MemoryTokenDenylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, ok := d.revoked[jti]
+	return ok, nil
+}
+
+// SQLTokenDenylist хранит отозванные токены в таблице REVOKED_TOKEN
+type SQLTokenDenylist struct {
+	db      *sql.DB
+	timeout int
+}
+
+func NewSQLTokenDenylist(timeout int, db *sql.DB) *SQLTokenDenylist {
+	return &SQLTokenDenylist{db: db, timeout: timeout}
+}
+
+func (d *SQLTokenDenylist) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(d.timeout)*time.Second)
+	defer cancel()
+
+	// Первичный ключ по JTI: вставка уже отозванного токена падает с нарушением уникальности
+	_, err := d.db.ExecContext(ctx, `
+		DELETE FROM REVOKED_TOKEN WHERE EXPIRES_AT < GETDATE();
+
+		INSERT INTO REVOKED_TOKEN (JTI, EXPIRES_AT) VALUES (@jti, @expires_at)
+	`, sql.Named("jti", jti), sql.Named("expires_at", expiresAt))
+	if isUniqueViolation(err) {
+		return ErrTokenAlreadyRevoked
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+func (d *SQLTokenDenylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(d.timeout)*time.Second)
+	defer cancel()
+
+	var exists int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT COUNT(1) FROM REVOKED_TOKEN WHERE JTI = @jti
+	`, sql.Named("jti", jti)).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check revoked token: %w", err)
+	}
+	return exists > 0, nil
+}
@@ -0,0 +1,98 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryTokenDenylistRevoke(t *testing.T) {
+	ctx := context.Background()
+	d := NewMemoryTokenDenylist()
+
+	revoked, err := d.IsRevoked(ctx, "a")
+	if err != nil || revoked {
+		t.Fatalf("IsRevoked before revoke = %v, %v; want false, nil", revoked, err)
+	}
+
+	if err := d.Revoke(ctx, "a", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	revoked, err = d.IsRevoked(ctx, "a")
+	if err != nil || !revoked {
+		t.Fatalf("IsRevoked after revoke = %v, %v; want true, nil", revoked, err)
+	}
+
+	revoked, _ = d.IsRevoked(ctx, "b")
+	if revoked {
+		t.Fatal("IsRevoked for another jti = true; want false")
+	}
+}
+
+func TestMemoryTokenDenylistRevokeTwice(t *testing.T) {
+	ctx := context.Background()
+	d := NewMemoryTokenDenylist()
+
+	if err := d.Revoke(ctx, "a", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("first Revoke: %v", err)
+	}
+	if err := d.Revoke(ctx, "a", time.Now().Add(time.Hour)); !errors.Is(err, ErrTokenAlreadyRevoked) {
+		t.Fatalf("second Revoke = %v; want ErrTokenAlreadyRevoked", err)
+	}
+}
+
+func TestMemoryTokenDenylistConcurrentRevoke(t *testing.T) {
+	ctx := context.Background()
+	d := NewMemoryTokenDenylist()
+
+	const n = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := d.Revoke(ctx, "a", time.Now().Add(time.Hour))
+			if err != nil && !errors.Is(err, ErrTokenAlreadyRevoked) {
+				t.Errorf("Revoke: %v", err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Fatalf("%d of %d concurrent revokes succeeded; want exactly 1", succeeded, n)
+	}
+}
+
+func TestMemoryTokenDenylistDropsExpired(t *testing.T) {
+	ctx := context.Background()
+	d := NewMemoryTokenDenylist()
+
+	if err := d.Revoke(ctx, "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Revoke expired: %v", err)
+	}
+	if err := d.Revoke(ctx, "live", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Revoke live: %v", err)
+	}
+
+	if revoked, _ := d.IsRevoked(ctx, "expired"); revoked {
+		t.Fatal("expired jti is still in the denylist after the next Revoke")
+	}
+	if revoked, _ := d.IsRevoked(ctx, "live"); !revoked {
+		t.Fatal("live jti is missing from the denylist")
+	}
+	if len(d.revoked) != 1 {
+		t.Fatalf("denylist holds %d entries; want 1", len(d.revoked))
+	}
+}
@@ -43,3 +43,89 @@ func (r *UserRepository) GetAllUsers() ([]models.User, error) {
 
 	return users, nil
 }
+
+// GetUserByName возвращает пользователя meta_user; nil, если не найден
+func (r *UserRepository) GetUserByName(ctx context.Context, name string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	var u models.User
+	err := r.db.QueryRowContext(ctx, `
+		SELECT name, full_name, user_num FROM meta_user WHERE name = @name
+	`, sql.Named("name", name)).Scan(&u.Name, &u.FullName, &u.UserNum)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return &u, nil
+}
+
+// GetPreferences возвращает настройки пользователя с подставленными значениями по умолчанию
+func (r *UserRepository) GetPreferences(ctx context.Context, name string) (*models.UserPreferences, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	prefs := models.UserPreferences{
+		DefaultDays: models.DefaultPreferenceDays,
+		PageSize:    models.DefaultPreferencePageSize,
+	}
+
+	var days, pageSize sql.NullInt64
+	var contractor sql.NullString
+	err := r.db.QueryRowContext(ctx, `
+		SELECT DEFAULT_DAYS, CAST(DEFAULT_CONTRACTOR AS VARCHAR(36)), PAGE_SIZE
+		FROM USER_PREFERENCE
+		WHERE USER_NAME = @name
+	`, sql.Named("name", name)).Scan(&days, &contractor, &pageSize)
+	if err == sql.ErrNoRows {
+		return &prefs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user preferences: %w", err)
+	}
+
+	if days.Valid {
+		prefs.DefaultDays = int(days.Int64)
+	}
+	if pageSize.Valid {
+		prefs.PageSize = int(pageSize.Int64)
+	}
+	prefs.DefaultContractor = contractor.String
+
+	return &prefs, nil
+}
+
+// SavePreferences сохраняет настройки пользователя
+func (r *UserRepository) SavePreferences(ctx context.Context, name string, prefs models.UserPreferences) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	var contractor interface{}
+	if prefs.DefaultContractor != "" {
+		contractor = prefs.DefaultContractor
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		MERGE USER_PREFERENCE WITH (HOLDLOCK) AS t
+		USING (SELECT @name AS USER_NAME) AS s ON t.USER_NAME = s.USER_NAME
+		WHEN MATCHED THEN
+			UPDATE SET DEFAULT_DAYS = @days, DEFAULT_CONTRACTOR = @contractor,
+			           PAGE_SIZE = @page_size, UPDATED_AT = GETDATE()
+		WHEN NOT MATCHED THEN
+			INSERT (USER_NAME, DEFAULT_DAYS, DEFAULT_CONTRACTOR, PAGE_SIZE)
+			VALUES (@name, @days, @contractor, @page_size);
+	`,
+		sql.Named("name", name),
+		sql.Named("days", prefs.DefaultDays),
+		sql.Named("contractor", contractor),
+		sql.Named("page_size", prefs.PageSize),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save user preferences: %w", err)
+	}
+
+	return nil
+}
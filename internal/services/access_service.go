@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"RemainsManager/internal/models"
+	"RemainsManager/internal/repositories"
+)
+
+// AccessService определяет, с какими аптеками может работать пользователь
+type AccessService struct {
+	repo *repositories.AccessRepository
+}
+
+func NewAccessService(repo *repositories.AccessRepository) *AccessService {
+	return &AccessService{repo: repo}
+}
+
+// Scope возвращает область видимости пользователя или API-ключа.
+// Разрешение pharmacies:all открывает всю сеть, иначе — только закреплённые аптеки
+// (у ключа — закреплённые при создании, USER_NAME = apikey:<имя>).
+func (s *AccessService) Scope(ctx context.Context, claims *models.UserClaims) (*models.ContractorScope, error) {
+	if claims.HasPermission(models.PermissionAllPharmacies) {
+		return &models.ContractorScope{All: true, ContractorIDs: []string{}}, nil
+	}
+
+	ids, err := s.repo.GetUserContractors(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ContractorScope{ContractorIDs: ids}, nil
+}
+
+func (s *AccessService) GetUserContractors(ctx context.Context, username string) ([]string, error) {
+	return s.repo.GetUserContractors(ctx, username)
+}
+
+func (s *AccessService) SetUserContractors(ctx context.Context, username string, contractorIDs []string) error {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(contractorIDs))
+	for _, id := range contractorIDs {
+		key := strings.ToLower(strings.TrimSpace(id))
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	return s.repo.SetUserContractors(ctx, username, unique)
+}
+
+func (s *AccessService) GetUserRoles(ctx context.Context, username string) ([]string, error) {
+	return s.repo.GetUserRoles(ctx, username)
+}
+
+func (s *AccessService) SetUserRoles(ctx context.Context, username string, roles []string) error {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !seen[role] {
+			seen[role] = true
+			unique = append(unique, role)
+		}
+	}
+	return s.repo.SetUserRoles(ctx, username, unique)
+}
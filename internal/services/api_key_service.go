@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"RemainsManager/internal/models"
+	"RemainsManager/internal/repositories"
+)
+
+// apiKeyPrefix помечает ключи этого сервиса, чтобы их было легко найти в конфигурациях
+const apiKeyPrefix = "rm_"
+
+// APIKeyService выпускает, проверяет и отзывает API-ключи
+type APIKeyService struct {
+	repo *repositories.APIKeyRepository
+}
+
+func NewAPIKeyService(repo *repositories.APIKeyRepository) *APIKeyService {
+	return &APIKeyService{repo: repo}
+}
+
+// CreateAPIKey выпускает новый ключ и закрепляет его за аптеками contractorIDs.
+// Ключ в открытом виде возвращается только здесь.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, name string, scopes, contractorIDs []string, createdBy string) (*models.CreatedAPIKey, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate api key: %w", err)
+	}
+	secret := apiKeyPrefix + hex.EncodeToString(b)
+
+	seen := make(map[string]bool)
+	contractors := make([]string, 0, len(contractorIDs))
+	for _, id := range contractorIDs {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if !seen[id] {
+			seen[id] = true
+			contractors = append(contractors, id)
+		}
+	}
+
+	key := models.APIKey{
+		Name:          name,
+		Prefix:        secret[:len(apiKeyPrefix)+8],
+		Scopes:        scopes,
+		ContractorIDs: contractors,
+		CreatedBy:     createdBy,
+	}
+
+	id, err := s.repo.CreateAPIKey(ctx, &key, hashAPIKey(secret))
+	if err != nil {
+		return nil, err
+	}
+	key.ID = id
+
+	log.Printf("[SECURITY] API key %q (id=%d) created by %s with scopes %v and pharmacies %v", name, id, createdBy, scopes, contractors)
+	return &models.CreatedAPIKey{APIKey: key, Key: secret}, nil
+}
+
+func (s *APIKeyService) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	return s.repo.GetAPIKeys(ctx)
+}
+
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id int64, revokedBy string) error {
+	if err := s.repo.RevokeAPIKey(ctx, id); err != nil {
+		return err
+	}
+	log.Printf("[SECURITY] API key id=%d revoked by %s", id, revokedBy)
+	return nil
+}
+
+// VerifyAPIKey возвращает данные сервисной учётной записи для действующего ключа;
+// nil без ошибки — ключ неизвестен или отозван. Используется AuthMiddleware.
+func (s *APIKeyService) VerifyAPIKey(ctx context.Context, secret string) (*models.UserClaims, error) {
+	key, err := s.repo.GetActiveAPIKeyByHash(ctx, hashAPIKey(secret))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	if err := s.repo.TouchAPIKey(ctx, key.ID); err != nil {
+		log.Printf("Failed to update usage of api key %d: %v", key.ID, err)
+	}
+
+	subject := models.APIKeySubjectPrefix + key.Name
+	claims := &models.UserClaims{
+		Name:     subject,
+		FullName: key.Name,
+		Scopes:   key.Scopes,
+		Type:     models.TokenTypeAccess,
+	}
+	claims.Subject = subject
+	return claims, nil
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
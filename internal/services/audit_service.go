@@ -0,0 +1,22 @@
+package services
+
+import (
+	"context"
+
+	"RemainsManager/internal/models"
+	"RemainsManager/internal/repositories"
+)
+
+// AuditService — чтение журнала аудита для администраторов.
+// Записи пишутся напрямую через repositories.AuditWriter из AuthService и AuditMiddleware.
+type AuditService struct {
+	repo *repositories.AuditRepository
+}
+
+func NewAuditService(repo *repositories.AuditRepository) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+func (s *AuditService) GetAuditLog(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	return s.repo.GetAuditLog(ctx, filter)
+}
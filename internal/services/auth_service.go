@@ -1,34 +1,290 @@
 package services
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"log"
 	"time"
 
+	"RemainsManager/config"
+	"RemainsManager/internal/models"
 	"RemainsManager/internal/repositories"
-	"RemainsManager/package/utils"
 )
 
+var (
+	// ErrInvalidToken — токен не прошёл проверку, истёк или отозван
+	ErrInvalidToken = errors.New("invalid or revoked token")
+	// ErrInvalidCredentials — неверный логин или пароль
+	ErrInvalidCredentials = errors.New("Invalid username or password")
+)
+
+// userRoleSource — источник ролей пользователя (AccessRepository)
+type userRoleSource interface {
+	GetUserRoles(ctx context.Context, username string) ([]string, error)
+}
+
 type AuthService struct {
-	repo      *repositories.AuthRepository
-	jwtSecret string
+	providers  []Authenticator
+	accessRepo userRoleSource
+	denylist   repositories.TokenDenylist
+	audit      repositories.AuditWriter
+	limiter    *LoginLimiter
+	security   config.SecurityConfig
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewAuthService(
+	providers []Authenticator,
+	accessRepo *repositories.AccessRepository,
+	denylist repositories.TokenDenylist,
+	audit repositories.AuditWriter,
+	cfg config.SecurityConfig,
+) *AuthService {
+	return &AuthService{
+		providers:  providers,
+		accessRepo: accessRepo,
+		denylist:   denylist,
+		audit:      audit,
+		limiter:    NewLoginLimiter(cfg),
+		security:   cfg,
+		accessTTL:  time.Duration(cfg.AccessTokenTTL) * time.Minute,
+		refreshTTL: time.Duration(cfg.RefreshTokenTTL) * time.Hour,
+	}
+}
+
+// Authenticate проверяет логин и пароль по источникам учётных записей в порядке конфигурации
+// и выдаёт пару токенов. Неудачные попытки учитываются по логину и IP; при блокировке
+// возвращается *LoginLockedError.
+func (s *AuthService) Authenticate(ctx context.Context, username, password, ip string) (*models.TokenPair, error) {
+	if err := s.limiter.Check(username, ip); err != nil {
+		log.Printf("[SECURITY] Rejected login for %q from %s: %v", username, ip, err)
+		s.writeAudit(ctx, username, ip, models.AuditActionLoginFailed, models.AuditOutcomeDenied, err.Error())
+		return nil, err
+	}
+
+	var lastErr error
+	rejected := false
+	for _, p := range s.providers {
+		user, err := p.Authenticate(ctx, username, password)
+		if err == nil {
+			s.limiter.Success(username)
+			s.writeAudit(ctx, user.Name, ip, models.AuditActionLogin, models.AuditOutcomeSuccess, "via "+p.Name())
+			return s.issueTokens(ctx, user)
+		}
+		if errors.Is(err, ErrInvalidCredentials) {
+			rejected = true
+			continue
+		}
+		log.Printf("Authenticator %s failed for %q: %v", p.Name(), username, err)
+		lastErr = err
+	}
+
+	// Отказ недоступного источника сам по себе не считается неудачной попыткой,
+	// но если хоть один источник отверг пароль, попытка учитывается: иначе падение
+	// одного источника отключало бы защиту от подбора для остальных
+	if lastErr != nil && !rejected {
+		s.writeAudit(ctx, username, ip, models.AuditActionLoginFailed, models.AuditOutcomeFailure, lastErr.Error())
+		return nil, fmt.Errorf("failed to authenticate: %w", lastErr)
+	}
+
+	s.limiter.Failure(username, ip)
+	s.writeAudit(ctx, username, ip, models.AuditActionLoginFailed, models.AuditOutcomeDenied, ErrInvalidCredentials.Error())
+	return nil, ErrInvalidCredentials
+}
+
+// writeAudit записывает событие входа; ошибка записи не мешает входу
+func (s *AuthService) writeAudit(ctx context.Context, username, ip, action, outcome, details string) {
+	entry := &models.AuditEntry{
+		User:    username,
+		IP:      ip,
+		Action:  action,
+		Outcome: outcome,
+		Details: details,
+	}
+	if err := s.audit.WriteAudit(context.WithoutCancel(ctx), entry); err != nil {
+		log.Printf("Failed to write audit entry: %v", err)
+	}
 }
 
-func NewAuthService(repo *repositories.AuthRepository, secret string) *AuthService {
-	return &AuthService{repo: repo, jwtSecret: secret}
+// Refresh выдаёт новую пару токенов по refresh-токену.
+// Использованный refresh-токен отзывается до выдачи новых: отзыв атомарен, поэтому
+// из одновременных обновлений одним токеном успешно только одно. Роли перечитываются из базы.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*models.TokenPair, error) {
+	claims, err := s.parseToken(ctx, refreshToken, models.TokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.revoke(ctx, claims); err != nil {
+		if errors.Is(err, repositories.ErrTokenAlreadyRevoked) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	user, err := s.lookupUser(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, user)
 }
 
-func (s *AuthService) Authenticate(username, password string) (string, error) {
-	hashedPass, err := s.repo.GetUserByUsername(username)
-	if err != nil || hashedPass == "" || hashedPass != localutils.HashSum(username, password) {
-		return "", errors.New("Invalid username or password")
+// Logout отзывает текущий токен доступа и, если передан, refresh-токен того же пользователя
+func (s *AuthService) Logout(ctx context.Context, access *models.UserClaims, refreshToken string) error {
+	if err := s.revoke(ctx, access); err != nil && !errors.Is(err, repositories.ErrTokenAlreadyRevoked) {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	refresh, err := s.parseToken(ctx, refreshToken, models.TokenTypeRefresh)
+	if err != nil {
+		// Уже отозванный или истёкший refresh-токен не мешает выходу
+		return nil
+	}
+	if refresh.Subject != access.Subject {
+		return ErrInvalidToken
 	}
+	if err := s.revoke(ctx, refresh); err != nil && !errors.Is(err, repositories.ErrTokenAlreadyRevoked) {
+		return err
+	}
+	return nil
+}
 
-	claims := &jwt.StandardClaims{
-		ExpiresAt: time.Now().Add(time.Hour * 72).Unix(),
-		Issuer:    "RemainManager",
+// lookupUser ищет учётную запись в источниках по порядку.
+// Ошибка возвращается, только если ни один источник пользователя не нашёл.
+func (s *AuthService) lookupUser(ctx context.Context, username string) (*models.User, error) {
+	var lastErr error
+	for _, p := range s.providers {
+		user, err := p.LookupUser(ctx, username)
+		if err != nil {
+			log.Printf("Authenticator %s failed to look up %q: %v", p.Name(), username, err)
+			lastErr = err
+			continue
+		}
+		if user != nil {
+			return user, nil
+		}
 	}
 
+	if lastErr != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", lastErr)
+	}
+	return nil, ErrInvalidToken
+}
+
+func (s *AuthService) issueTokens(ctx context.Context, user *models.User) (*models.TokenPair, error) {
+	roles, err := s.userRoles(ctx, user.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	access, err := s.sign(user, roles, models.TokenTypeAccess, now.Add(s.accessTTL))
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := s.sign(user, roles, models.TokenTypeRefresh, now.Add(s.refreshTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(s.accessTTL.Seconds()),
+	}, nil
+}
+
+func (s *AuthService) sign(user *models.User, roles []string, tokenType string, expiresAt time.Time) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &models.UserClaims{
+		Name:     user.Name,
+		FullName: user.FullName,
+		UserNum:  user.UserNum,
+		Roles:    roles,
+		Type:     tokenType,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   user.Name,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Issuer:    "RemainManager",
+		},
+	}
+
+	kid, secret := s.security.SigningKey()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.jwtSecret))
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	return token.SignedString([]byte(secret))
+}
+
+// parseToken проверяет подпись, срок действия, тип и отсутствие в списке отозванных
+func (s *AuthService) parseToken(ctx context.Context, tokenString, tokenType string) (*models.UserClaims, error) {
+	claims := &models.UserClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		kid, _ := token.Header["kid"].(string)
+		secret, ok := s.security.VerificationKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return []byte(secret), nil
+	})
+	if err != nil || !token.Valid || claims.Type != tokenType || claims.Id == "" {
+		return nil, ErrInvalidToken
+	}
+
+	revoked, err := s.denylist.IsRevoked(ctx, claims.Id)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (s *AuthService) revoke(ctx context.Context, claims *models.UserClaims) error {
+	if claims.Id == "" {
+		return nil
+	}
+	return s.denylist.Revoke(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0))
+}
+
+// userRoles возвращает роли пользователя; без назначений — роль по умолчанию
+func (s *AuthService) userRoles(ctx context.Context, username string) ([]string, error) {
+	roles, err := s.accessRepo.GetUserRoles(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load roles: %w", err)
+	}
+	if len(roles) == 0 {
+		roles = []string{models.DefaultRole}
+	}
+	return roles, nil
+}
+
+// newTokenID генерирует случайный идентификатор токена (jti)
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
 }
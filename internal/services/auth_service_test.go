@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"RemainsManager/config"
+	"RemainsManager/internal/models"
+	"RemainsManager/internal/repositories"
+)
+
+// stubAuthenticator — источник учётных записей с заранее заданным ответом
+type stubAuthenticator struct {
+	name string
+	user *models.User
+	err  error
+}
+
+func (a *stubAuthenticator) Name() string { return a.name }
+
+func (a *stubAuthenticator) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	return a.user, a.err
+}
+
+func (a *stubAuthenticator) LookupUser(ctx context.Context, username string) (*models.User, error) {
+	return a.user, nil
+}
+
+type stubRoles struct{}
+
+func (stubRoles) GetUserRoles(ctx context.Context, username string) ([]string, error) {
+	return []string{models.DefaultRole}, nil
+}
+
+type discardAudit struct{}
+
+func (discardAudit) WriteAudit(ctx context.Context, entry *models.AuditEntry) error { return nil }
+
+func newTestAuthService(providers ...Authenticator) *AuthService {
+	cfg := config.SecurityConfig{
+		JWTSecret:             "test-secret",
+		AccessTokenTTL:        15,
+		RefreshTokenTTL:       24,
+		LoginMaxAttempts:      3,
+		LoginMaxAttemptsPerIP: 100,
+		LoginLockout:          15,
+	}
+	s := NewAuthService(providers, nil, repositories.NewMemoryTokenDenylist(), discardAudit{}, cfg)
+	s.accessRepo = stubRoles{}
+	return s
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthService(&stubAuthenticator{name: "erp", user: &models.User{Name: "ivanov"}})
+
+	pair, err := s.Authenticate(ctx, "ivanov", "secret", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	next, err := s.Refresh(ctx, pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if next.RefreshToken == pair.RefreshToken {
+		t.Fatal("Refresh returned the same refresh token")
+	}
+
+	if _, err := s.Refresh(ctx, pair.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("second Refresh with a used token = %v; want ErrInvalidToken", err)
+	}
+	if _, err := s.Refresh(ctx, next.RefreshToken); err != nil {
+		t.Fatalf("Refresh with the rotated token: %v", err)
+	}
+}
+
+func TestConcurrentRefreshSucceedsOnce(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthService(&stubAuthenticator{name: "erp", user: &models.User{Name: "ivanov"}})
+
+	pair, err := s.Authenticate(ctx, "ivanov", "secret", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := s.Refresh(ctx, pair.RefreshToken)
+			if err != nil && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Refresh: %v", err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Fatalf("%d of %d concurrent refreshes succeeded; want exactly 1", succeeded, n)
+	}
+}
+
+func TestAuthenticateCountsRejectionWhenAnotherProviderFails(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthService(
+		&stubAuthenticator{name: "erp", err: ErrInvalidCredentials},
+		&stubAuthenticator{name: "ldap", err: errors.New("ldap unreachable")},
+	)
+
+	if _, err := s.Authenticate(ctx, "ivanov", "wrong", "10.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate = %v; want ErrInvalidCredentials", err)
+	}
+
+	var locked *LoginLockedError
+	if _, err := s.Authenticate(ctx, "ivanov", "wrong", "10.0.0.1"); !errors.As(err, &locked) {
+		t.Fatalf("Authenticate after a rejected attempt = %v; want *LoginLockedError", err)
+	}
+}
+
+func TestAuthenticateProviderFailureIsNotCounted(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthService(&stubAuthenticator{name: "ldap", err: errors.New("ldap unreachable")})
+
+	for i := 0; i < 5; i++ {
+		_, err := s.Authenticate(ctx, "ivanov", "secret", "10.0.0.1")
+		if err == nil || errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: Authenticate = %v; want a provider error", i+1, err)
+		}
+		var locked *LoginLockedError
+		if errors.As(err, &locked) {
+			t.Fatalf("attempt %d: login locked although no provider rejected the password", i+1)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"RemainsManager/internal/models"
+	"RemainsManager/internal/repositories"
+	"RemainsManager/package/utils"
+)
+
+// Authenticator проверяет логин и пароль в одном источнике учётных записей.
+// AuthService опрашивает источники по порядку из security.authenticators:
+// ErrInvalidCredentials означает, что источник пользователя не подтвердил, и проверка
+// переходит к следующему; любая другая ошибка — источник недоступен.
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx context.Context, username, password string) (*models.User, error)
+	// LookupUser проверяет при обновлении токена, что учётная запись всё ещё существует; nil — не найдена
+	LookupUser(ctx context.Context, username string) (*models.User, error)
+}
+
+// ERPAuthenticator — вход по учётной записи ERP (meta_user, хеш MD5 от UTF-16)
+type ERPAuthenticator struct {
+	repo *repositories.AuthRepository
+}
+
+func NewERPAuthenticator(repo *repositories.AuthRepository) *ERPAuthenticator {
+	return &ERPAuthenticator{repo: repo}
+}
+
+func (a *ERPAuthenticator) Name() string {
+	return "erp"
+}
+
+func (a *ERPAuthenticator) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := a.repo.GetUserByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	if user.PasswordHash == "" || user.PasswordHash != localutils.HashSum(username, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &user.User, nil
+}
+
+func (a *ERPAuthenticator) LookupUser(ctx context.Context, username string) (*models.User, error) {
+	user, err := a.repo.GetUserByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	return &user.User, nil
+}
@@ -85,9 +85,9 @@ func (s *AutoDistributeService) Distribute(ctx context.Context, fromID string, d
 		// Берём топ-3 получателя
 		topReceivers := filtered[:int(math.Min(3, float64(len(filtered))))]
 
-		// Распределяем количество: поровну + остаток первому
-		qtyPerReceiver := math.Floor(prod.Qty / float64(len(topReceivers)))
-		remainder := int(prod.Qty) - int(qtyPerReceiver*float64(len(topReceivers)))
+		// Распределяем доступное количество (за вычетом резервов других заявок): поровну + остаток первому
+		qtyPerReceiver := math.Floor(prod.QtyAvailable / float64(len(topReceivers)))
+		remainder := int(prod.QtyAvailable) - int(qtyPerReceiver*float64(len(topReceivers)))
 
 		for i, receiver := range topReceivers {
 			qty := qtyPerReceiver
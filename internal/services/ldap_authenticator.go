@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"RemainsManager/config"
+	"RemainsManager/internal/models"
+	"RemainsManager/internal/repositories"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPAuthenticator — вход по доменной учётной записи (LDAP / Active Directory).
+// Пользователь ищется по фильтру под служебной учётной записью, пароль проверяется
+// bind'ом под найденным DN. Данные ERP (ФИО, номер) берутся из meta_user по имени, если он там есть.
+type LDAPAuthenticator struct {
+	cfg   config.LDAPConfig
+	users *repositories.UserRepository
+	dial  func() (ldapConn, error) // по умолчанию connect; в тестах — подставной каталог
+}
+
+// ldapConn — операции с соединением LDAP, которые нужны для входа (*ldap.Conn)
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+func NewLDAPAuthenticator(cfg config.LDAPConfig, users *repositories.UserRepository) *LDAPAuthenticator {
+	a := &LDAPAuthenticator{cfg: cfg, users: users}
+	a.dial = a.connect
+	return a
+}
+
+func (a *LDAPAuthenticator) Name() string {
+	return "ldap"
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	// Bind с пустым паролем на многих серверах проходит как анонимный
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := a.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap bind failed: %w", err)
+	}
+
+	return a.toUser(ctx, entry, username)
+}
+
+func (a *LDAPAuthenticator) LookupUser(ctx context.Context, username string) (*models.User, error) {
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := a.findUser(conn, username)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	return a.toUser(ctx, entry, username)
+}
+
+func (a *LDAPAuthenticator) connect() (ldapConn, error) {
+	timeout := time.Duration(a.cfg.Timeout) * time.Second
+
+	u, err := url.Parse(a.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: a.cfg.InsecureSkipVerify,
+	}
+
+	conn, err := ldap.DialURL(a.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ldap: %w", err)
+	}
+	conn.SetTimeout(timeout)
+
+	if a.cfg.StartTLS && u.Scheme == "ldap" {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls failed: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// findUser ищет единственную запись пользователя; nil — не найдена или найдено несколько
+func (a *LDAPAuthenticator) findUser(conn ldapConn, username string) (*ldap.Entry, error) {
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind failed: %w", err)
+		}
+	}
+
+	req := ldap.NewSearchRequest(
+		a.cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, a.cfg.Timeout, false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(username)),
+		[]string{a.cfg.UsernameAttribute, a.cfg.FullNameAttribute},
+		nil,
+	)
+
+	result, err := conn.Search(req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap search failed: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, nil
+	}
+
+	return result.Entries[0], nil
+}
+
+func (a *LDAPAuthenticator) toUser(ctx context.Context, entry *ldap.Entry, username string) (*models.User, error) {
+	name := entry.GetAttributeValue(a.cfg.UsernameAttribute)
+	if name == "" {
+		name = username
+	}
+
+	user, err := a.users.GetUserByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	return &models.User{
+		Name:     name,
+		FullName: entry.GetAttributeValue(a.cfg.FullNameAttribute),
+	}, nil
+}
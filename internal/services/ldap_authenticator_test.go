@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"RemainsManager/config"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// stubDirectory — подставной каталог LDAP: пароли по DN и записи, находимые по точному фильтру
+type stubDirectory struct {
+	passwords map[string]string
+	entries   map[string]*ldap.Entry // фильтр поиска → запись
+
+	binds    []string // DN в порядке bind
+	searches []*ldap.SearchRequest
+}
+
+func (d *stubDirectory) Bind(username, password string) error {
+	d.binds = append(d.binds, username)
+	if want, ok := d.passwords[username]; !ok || want != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (d *stubDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	d.searches = append(d.searches, req)
+	result := &ldap.SearchResult{}
+	if entry, ok := d.entries[req.Filter]; ok && req.BaseDN == "dc=example,dc=org" {
+		result.Entries = append(result.Entries, entry)
+	}
+	return result, nil
+}
+
+func (d *stubDirectory) Close() error { return nil }
+
+const (
+	testServiceDN = "cn=svc,dc=example,dc=org"
+	testUserDN    = "uid=ivanov,ou=people,dc=example,dc=org"
+)
+
+func newTestLDAP(dir *stubDirectory, bindPassword string) *LDAPAuthenticator {
+	a := NewLDAPAuthenticator(config.LDAPConfig{
+		URL:               "ldap://localhost:3893",
+		BindDN:            testServiceDN,
+		BindPassword:      bindPassword,
+		BaseDN:            "dc=example,dc=org",
+		UserFilter:        "(uid=%s)",
+		UsernameAttribute: "uid",
+		FullNameAttribute: "cn",
+		Timeout:           5,
+	}, nil)
+	a.dial = func() (ldapConn, error) { return dir, nil }
+	return a
+}
+
+func newStubDirectory() *stubDirectory {
+	return &stubDirectory{
+		passwords: map[string]string{
+			testServiceDN: "svc-secret",
+			testUserDN:    "user-secret",
+		},
+		entries: map[string]*ldap.Entry{
+			"(uid=ivanov)": ldap.NewEntry(testUserDN, map[string][]string{
+				"uid": {"ivanov"},
+				"cn":  {"Иванов Иван"},
+			}),
+		},
+	}
+}
+
+func TestLDAPAuthenticateWrongPassword(t *testing.T) {
+	dir := newStubDirectory()
+	a := newTestLDAP(dir, "svc-secret")
+
+	_, err := a.Authenticate(context.Background(), "ivanov", "wrong")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate = %v; want ErrInvalidCredentials", err)
+	}
+
+	want := []string{testServiceDN, testUserDN}
+	if len(dir.binds) != len(want) || dir.binds[0] != want[0] || dir.binds[1] != want[1] {
+		t.Fatalf("binds = %v; want service bind then user bind %v", dir.binds, want)
+	}
+	if len(dir.searches) != 1 || dir.searches[0].Filter != "(uid=ivanov)" {
+		t.Fatalf("searches = %+v; want one search for (uid=ivanov)", dir.searches)
+	}
+}
+
+func TestLDAPAuthenticateUserNotFound(t *testing.T) {
+	dir := newStubDirectory()
+	a := newTestLDAP(dir, "svc-secret")
+
+	_, err := a.Authenticate(context.Background(), "petrov", "user-secret")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate = %v; want ErrInvalidCredentials", err)
+	}
+	if len(dir.binds) != 1 || dir.binds[0] != testServiceDN {
+		t.Fatalf("binds = %v; want only the service bind", dir.binds)
+	}
+}
+
+func TestLDAPAuthenticateEscapesFilter(t *testing.T) {
+	dir := newStubDirectory()
+	a := newTestLDAP(dir, "svc-secret")
+
+	_, err := a.Authenticate(context.Background(), "*)(uid=ivanov", "user-secret")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate = %v; want ErrInvalidCredentials", err)
+	}
+	if got := dir.searches[0].Filter; got != `(uid=\2a\29\28uid=ivanov)` {
+		t.Fatalf("search filter = %s; want the username escaped", got)
+	}
+}
+
+func TestLDAPAuthenticateServiceBindFailure(t *testing.T) {
+	dir := newStubDirectory()
+	a := newTestLDAP(dir, "wrong-svc-secret")
+
+	_, err := a.Authenticate(context.Background(), "ivanov", "user-secret")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate = %v; want a configuration error, not ErrInvalidCredentials", err)
+	}
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"RemainsManager/config"
+)
+
+// LoginLockedError — вход временно запрещён после неудачных попыток
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// LoginLimiter считает неудачные попытки входа по логину и по IP.
+// После каждой неудачи следующая попытка разрешается с экспоненциальной задержкой,
+// после достижения лимита ключ блокируется на время lockout.
+type LoginLimiter struct {
+	mu         sync.Mutex
+	attempts   map[string]*loginAttempts
+	maxPerUser int
+	maxPerIP   int
+	base       time.Duration
+	lockout    time.Duration
+}
+
+func NewLoginLimiter(cfg config.SecurityConfig) *LoginLimiter {
+	return &LoginLimiter{
+		attempts:   make(map[string]*loginAttempts),
+		maxPerUser: cfg.LoginMaxAttempts,
+		maxPerIP:   cfg.LoginMaxAttemptsPerIP,
+		base:       time.Duration(cfg.LoginBackoffBase) * time.Second,
+		lockout:    time.Duration(cfg.LoginLockout) * time.Minute,
+	}
+}
+
+// Check возвращает LoginLockedError, если логин или IP сейчас заблокированы
+func (l *LoginLimiter) Check(username, ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []string{userKey(username), ipKey(ip)} {
+		if a, ok := l.attempts[key]; ok && a.blockedUntil.After(now) {
+			if d := a.blockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait > 0 {
+		return &LoginLockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Failure учитывает неудачную попытку входа
+func (l *LoginLimiter) Failure(username, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+	l.fail(userKey(username), l.maxPerUser, now)
+	l.fail(ipKey(ip), l.maxPerIP, now)
+}
+
+// Success сбрасывает счётчик логина. Счётчик IP не сбрасывается,
+// чтобы вход под своей учётной записью не обнулял подбор чужих паролей.
+func (l *LoginLimiter) Success(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, userKey(username))
+}
+
+func (l *LoginLimiter) fail(key string, max int, now time.Time) {
+	a, ok := l.attempts[key]
+	if !ok || now.Sub(a.lastFailure) > l.lockout {
+		a = &loginAttempts{}
+		l.attempts[key] = a
+	}
+
+	a.failures++
+	a.lastFailure = now
+
+	if a.failures >= max {
+		a.blockedUntil = now.Add(l.lockout)
+		log.Printf("[SECURITY] Login locked out for %s after %d failed attempts until %s",
+			key, a.failures, a.blockedUntil.Format(time.RFC3339))
+		return
+	}
+
+	delay := l.base << (a.failures - 1)
+	if delay > l.lockout || delay <= 0 {
+		delay = l.lockout
+	}
+	a.blockedUntil = now.Add(delay)
+}
+
+// cleanup удаляет записи, по которым давно не было неудач
+func (l *LoginLimiter) cleanup(now time.Time) {
+	for key, a := range l.attempts {
+		if now.Sub(a.lastFailure) > l.lockout && a.blockedUntil.Before(now) {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+func userKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
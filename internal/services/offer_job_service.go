@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"RemainsManager/internal/models"
+)
+
+// offerJobRetention — сколько хранится завершённое задание, чтобы клиент успел забрать результат
+const offerJobRetention = time.Hour
+
+// ErrJobsShuttingDown — сервер останавливается и новые задания не принимает
+var ErrJobsShuttingDown = errors.New("server is shutting down, processing is not accepted")
+
+// OfferJobService выполняет обработку заявок в фоне: HTTP-запрос только ставит задание
+// и сразу возвращает его ID, а ход обработки клиент опрашивает по ID.
+// Задания хранятся в памяти процесса; на одну заявку одновременно выполняется одно задание.
+type OfferJobService struct {
+	offers *OfferService
+
+	mu       sync.Mutex
+	jobs     map[string]*models.OfferJob
+	active   map[int64]string // ID заявки → ID выполняющегося задания
+	closed   bool
+	inFlight sync.WaitGroup
+}
+
+func NewOfferJobService(offers *OfferService) *OfferJobService {
+	return &OfferJobService{
+		offers: offers,
+		jobs:   make(map[string]*models.OfferJob),
+		active: make(map[int64]string),
+	}
+}
+
+// StartProcessing ставит обработку заявки в фон и возвращает снимок задания.
+// Если по заявке уже выполняется задание, возвращается оно, а новое не создаётся.
+func (s *OfferJobService) StartProcessing(offerID int64, user models.User, comment string) (*models.OfferJob, error) {
+	return s.start(offerID, user, comment, false)
+}
+
+// StartRetry ставит в фон повторную обработку заявки в статусе "ошибка" по неудачным получателям
+func (s *OfferJobService) StartRetry(offerID int64, user models.User, comment string) (*models.OfferJob, error) {
+	return s.start(offerID, user, comment, true)
+}
+
+func (s *OfferJobService) start(offerID int64, user models.User, comment string, retry bool) (*models.OfferJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrJobsShuttingDown
+	}
+	s.purgeLocked()
+
+	if id, ok := s.active[offerID]; ok {
+		return snapshotJob(s.jobs[id]), nil
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	job := &models.OfferJob{
+		ID:        id,
+		OfferID:   offerID,
+		Retry:     retry,
+		User:      user.Name,
+		Status:    models.JobStatusQueued,
+		Receivers: []models.ReceiverProgress{},
+		CreatedAt: time.Now(),
+	}
+	s.jobs[id] = job
+	s.active[offerID] = id
+
+	s.inFlight.Add(1)
+	go s.run(job, user, comment)
+
+	return snapshotJob(job), nil
+}
+
+// GetJob возвращает снимок задания; false — задания нет или оно уже удалено по сроку хранения.
+// Просроченные задания удаляются и здесь, а не только при запуске нового.
+func (s *OfferJobService) GetJob(id string) (*models.OfferJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeLocked()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	return snapshotJob(job), true
+}
+
+// Shutdown перестаёт принимать задания и ждёт завершения выполняющихся или истечения ctx
+func (s *OfferJobService) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	running := len(s.active)
+	s.mu.Unlock()
+
+	if running > 0 {
+		log.Printf("Waiting for %d offer processing jobs to finish...", running)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("offer processing jobs did not finish: %w", ctx.Err())
+	}
+}
+
+// run выполняет задание. Контекст не связан с HTTP-запросом: обработка не прерывается,
+// когда клиент отключается, а при остановке сервера Shutdown дожидается её завершения.
+func (s *OfferJobService) run(job *models.OfferJob, user models.User, comment string) {
+	defer s.inFlight.Done()
+
+	s.update(job, func(j *models.OfferJob) {
+		now := time.Now()
+		j.Status = models.JobStatusRunning
+		j.StartedAt = &now
+	})
+
+	process := s.offers.ProcessOffer
+	if job.Retry {
+		process = s.offers.RetryOffer
+	}
+	result, err := process(context.Background(), job.OfferID, user, comment, &jobObserver{s: s, job: job})
+
+	s.update(job, func(j *models.OfferJob) {
+		now := time.Now()
+		j.FinishedAt = &now
+		if err != nil {
+			j.Status = models.JobStatusFailed
+			j.Error = err.Error()
+			var validationErr *models.OfferValidationError
+			if errors.As(err, &validationErr) {
+				j.Problems = validationErr.Problems
+			}
+			return
+		}
+		j.Status = models.JobStatusSucceeded
+		j.Stage = models.JobStageDone
+		j.Result = result
+	})
+
+	s.mu.Lock()
+	delete(s.active, job.OfferID)
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("[Job %s][Offer %d] Processing failed: %v", job.ID, job.OfferID, err)
+	}
+}
+
+// update меняет задание под блокировкой
+func (s *OfferJobService) update(job *models.OfferJob, fn func(*models.OfferJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(job)
+}
+
+// purgeLocked удаляет завершённые задания старше offerJobRetention; вызывается под s.mu
+// при запуске задания и при опросе (GetJob)
+func (s *OfferJobService) purgeLocked() {
+	cutoff := time.Now().Add(-offerJobRetention)
+	for id, job := range s.jobs {
+		if job.Finished() && job.FinishedAt.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// jobObserver переносит ход обработки заявки в задание
+type jobObserver struct {
+	s   *OfferJobService
+	job *models.OfferJob
+}
+
+func (o *jobObserver) Stage(stage string) {
+	o.s.update(o.job, func(j *models.OfferJob) {
+		j.Stage = stage
+	})
+}
+
+func (o *jobObserver) MovingsBuilt(movings []models.InterfirmMovingDoc) {
+	o.s.update(o.job, func(j *models.OfferJob) {
+		j.Receivers = make([]models.ReceiverProgress, 0, len(movings))
+		for _, m := range movings {
+			j.Receivers = append(j.Receivers, models.ReceiverProgress{
+				ContractorTo: m.ContractorTo,
+				MovingGlobal: m.MovingGlobal,
+				ItemCount:    m.ItemCount,
+				Status:       models.ReceiverPending,
+			})
+		}
+	})
+}
+
+func (o *jobObserver) MovingSaved(moving models.InterfirmMovingDoc) {
+	o.setReceiver(moving.ContractorTo, models.ReceiverSaved, "")
+}
+
+func (o *jobObserver) MovingFailed(moving models.InterfirmMovingDoc, err error) {
+	o.setReceiver(moving.ContractorTo, models.ReceiverFailed, err.Error())
+}
+
+func (o *jobObserver) setReceiver(contractorTo, status, cause string) {
+	o.s.update(o.job, func(j *models.OfferJob) {
+		for i := range j.Receivers {
+			if j.Receivers[i].ContractorTo == contractorTo {
+				j.Receivers[i].Status = status
+				j.Receivers[i].Error = cause
+			}
+		}
+	})
+}
+
+// snapshotJob копирует задание, чтобы отдать его наружу без гонок с фоновой горутиной
+func snapshotJob(job *models.OfferJob) *models.OfferJob {
+	c := *job
+	c.Receivers = append([]models.ReceiverProgress(nil), job.Receivers...)
+	return &c
+}
+
+// newJobID генерирует случайный идентификатор задания
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"RemainsManager/internal/models"
+)
+
+func TestGetJobPurgesExpiredJobs(t *testing.T) {
+	s := NewOfferJobService(nil)
+
+	expired := time.Now().Add(-offerJobRetention - time.Minute)
+	recent := time.Now().Add(-time.Minute)
+	s.jobs["old"] = &models.OfferJob{ID: "old", OfferID: 1, Status: models.JobStatusSucceeded, FinishedAt: &expired}
+	s.jobs["new"] = &models.OfferJob{ID: "new", OfferID: 2, Status: models.JobStatusFailed, FinishedAt: &recent}
+	s.jobs["running"] = &models.OfferJob{ID: "running", OfferID: 3, Status: models.JobStatusRunning}
+
+	if _, ok := s.GetJob("old"); ok {
+		t.Fatal("GetJob returned a job finished past the retention period")
+	}
+	if _, ok := s.GetJob("new"); !ok {
+		t.Fatal("GetJob did not return a recently finished job")
+	}
+	if _, ok := s.GetJob("running"); !ok {
+		t.Fatal("GetJob did not return a running job")
+	}
+
+	// Опрос одного задания освобождает и остальные просроченные
+	s.jobs["old"] = &models.OfferJob{ID: "old", OfferID: 1, Status: models.JobStatusSucceeded, FinishedAt: &expired}
+	s.GetJob("new")
+	if _, ok := s.jobs["old"]; ok {
+		t.Fatal("expired job is still held in memory after GetJob")
+	}
+}
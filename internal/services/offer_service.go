@@ -3,8 +3,11 @@ package services
 import (
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"strings"
 	"time"
 
 	"RemainsManager/internal/models"
@@ -23,16 +26,63 @@ func (s *OfferService) GetOrCreateTodayOffer(ctx context.Context, fromID, fromNa
 	return s.repo.GetOrCreateTodayOffer(ctx, fromID, fromName)
 }
 
+func (s *OfferService) CreateDraft(ctx context.Context, fromID, name string) (*models.Offer, error) {
+	return s.repo.CreateDraft(ctx, fromID, name)
+}
+
+func (s *OfferService) GetOpenDrafts(ctx context.Context, fromID string) ([]models.OfferDraft, error) {
+	return s.repo.GetOpenDrafts(ctx, fromID)
+}
+
 func (s *OfferService) AddItems(ctx context.Context, items []models.OfferItem) error {
 	return s.repo.AddItems(ctx, items)
 }
 
-func (s *OfferService) GetOfferJournal(ctx context.Context, from, to time.Time, contractorGlobal *string) ([]models.OfferJournalItem, error) {
-	return s.repo.GetOfferJournal(ctx, from, to, contractorGlobal)
+func (s *OfferService) GetOfferJournal(ctx context.Context, filter models.OfferJournalFilter) ([]models.OfferJournalItem, int, error) {
+	return s.repo.GetOfferJournal(ctx, filter)
+}
+
+func (s *OfferService) GetOffer(ctx context.Context, offerID int64) (*models.Offer, error) {
+	return s.repo.GetOffer(ctx, offerID)
+}
+
+func (s *OfferService) GetOfferByItem(ctx context.Context, itemID int64) (*models.Offer, error) {
+	return s.repo.GetOfferByItem(ctx, itemID)
+}
+
+func (s *OfferService) GetOfferDetails(ctx context.Context, offerID int64, salesDays int) ([]models.OfferDetailItem, error) {
+	return s.repo.GetOfferDetails(ctx, offerID, salesDays)
 }
 
-func (s *OfferService) GetOfferDetails(ctx context.Context, offerID int64) ([]models.OfferDetailItem, error) {
-	return s.repo.GetOfferDetails(ctx, offerID)
+// GetOfferDetailsByReceiver возвращает детали заявки, сгруппированные по получателям
+// в порядке их первого появления, с итогами по каждому получателю
+func (s *OfferService) GetOfferDetailsByReceiver(ctx context.Context, offerID int64, salesDays int) ([]models.OfferReceiverDetails, error) {
+	items, err := s.repo.GetOfferDetails(ctx, offerID, salesDays)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []models.OfferReceiverDetails{}
+	index := make(map[string]int)
+	for _, item := range items {
+		key := strings.ToUpper(item.ContractorToID)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, models.OfferReceiverDetails{
+				ContractorToID: item.ContractorToID,
+				ContractorTo:   item.ContractorTo,
+				Items:          []models.OfferDetailItem{},
+			})
+		}
+		g := &groups[i]
+		g.ItemCount++
+		g.TotalQuantity += item.Quantity
+		g.TotalValue += item.LineValue
+		g.Items = append(g.Items, item)
+	}
+	return groups, nil
 }
 
 func (s *OfferService) UpdateOfferItem(ctx context.Context, id int64, quantity int) error {
@@ -46,78 +96,548 @@ func (s *OfferService) DeleteOfferItem(ctx context.Context, id int64) error {
 	return s.repo.DeleteOfferItem(ctx, id)
 }
 
-func (s *OfferService) MarkAsSent(ctx context.Context, offerID int64) error {
-	return s.repo.UpdateOfferStatus(ctx, offerID, models.OfferStatusSent)
+// DeleteReceiverItems удаляет из заявки все позиции получателя одной транзакцией
+func (s *OfferService) DeleteReceiverItems(ctx context.Context, offerID int64, receiverID string) (*models.OfferBulkResult, error) {
+	return s.repo.EditItems(ctx, offerID, func(items []models.OfferItem) []models.OfferItemPatch {
+		patches := []models.OfferItemPatch{}
+		for _, item := range items {
+			if strings.EqualFold(item.IdContractorGlobalTo, receiverID) {
+				patches = append(patches, models.OfferItemPatch{ItemID: item.ID, Delete: true})
+			}
+		}
+		return patches
+	})
+}
+
+// MoveItems переносит позиции другому получателю: перечисленные в req.ItemIDs
+// или, если список пуст, все позиции req.FromReceiver. Если заданы оба,
+// позиции чужих получателей не проходят проверку.
+func (s *OfferService) MoveItems(ctx context.Context, offerID int64, req models.MoveItemsRequest) (*models.OfferBulkResult, error) {
+	to := req.ToReceiver
+	return s.repo.EditItems(ctx, offerID, func(items []models.OfferItem) []models.OfferItemPatch {
+		patches := []models.OfferItemPatch{}
+		if len(req.ItemIDs) > 0 {
+			for _, id := range req.ItemIDs {
+				patches = append(patches, models.OfferItemPatch{ItemID: id, ContractorTo: &to, FromReceiver: req.FromReceiver})
+			}
+			return patches
+		}
+		for _, item := range items {
+			if strings.EqualFold(item.IdContractorGlobalTo, req.FromReceiver) {
+				patches = append(patches, models.OfferItemPatch{ItemID: item.ID, ContractorTo: &to})
+			}
+		}
+		return patches
+	})
+}
+
+// ScaleItems пересчитывает количества позиций в процентах от текущего; см. models.ScaleItemsRequest
+func (s *OfferService) ScaleItems(ctx context.Context, offerID int64, req models.ScaleItemsRequest) (*models.OfferBulkResult, error) {
+	return s.repo.EditItems(ctx, offerID, func(items []models.OfferItem) []models.OfferItemPatch {
+		patches := []models.OfferItemPatch{}
+		for _, item := range items {
+			if req.ReceiverID != "" && !strings.EqualFold(item.IdContractorGlobalTo, req.ReceiverID) {
+				continue
+			}
+			quantity := int(math.Round(float64(item.Quantity) * req.Percent / 100))
+			if quantity < 1 {
+				quantity = 1
+			}
+			patches = append(patches, models.OfferItemPatch{ItemID: item.ID, Quantity: &quantity})
+		}
+		return patches
+	})
+}
+
+// PatchItems применяет список правок позиций заявки одной транзакцией
+func (s *OfferService) PatchItems(ctx context.Context, offerID int64, patches []models.OfferItemPatch) (*models.OfferBulkResult, error) {
+	return s.repo.EditItems(ctx, offerID, func([]models.OfferItem) []models.OfferItemPatch {
+		return patches
+	})
+}
+
+func (s *OfferService) GetOfferStatusHistory(ctx context.Context, offerID int64) ([]models.OfferStatusChange, error) {
+	return s.repo.GetOfferStatusHistory(ctx, offerID)
+}
+
+func (s *OfferService) MarkAsSent(ctx context.Context, offerID int64, userName, comment string) error {
+	return s.transition(ctx, &models.OfferStatusChange{
+		OfferID: offerID,
+		To:      models.OfferStatusSent,
+		User:    userName,
+		Comment: comment,
+	}, "send")
+}
+
+func (s *OfferService) DeleteOffer(ctx context.Context, offerID int64, userName, comment string) error {
+	return s.transition(ctx, &models.OfferStatusChange{
+		OfferID: offerID,
+		To:      models.OfferStatusDeleted,
+		User:    userName,
+		Comment: comment,
+	}, "delete")
+}
+
+// transition переводит заявку в статус change.To, если переход из текущего статуса разрешён
+// models.CanTransitionOffer, и записывает его в историю. Запрещённый переход возвращает *models.OfferStateError.
+func (s *OfferService) transition(ctx context.Context, change *models.OfferStatusChange, action string) error {
+	offer, err := s.repo.GetOffer(ctx, change.OfferID)
+	if err != nil {
+		return err
+	}
+
+	if !models.CanTransitionOffer(offer.Status, change.To) {
+		return &models.OfferStateError{OfferID: change.OfferID, Status: offer.Status, Action: action}
+	}
+
+	change.From = offer.Status
+	return s.repo.ChangeOfferStatus(ctx, change)
+}
+
+// ProcessObserver получает ход обработки заявки; используется фоновыми заданиями
+type ProcessObserver interface {
+	// Stage — начался этап models.JobStage*
+	Stage(stage string)
+	// MovingsBuilt — XML готов, известен список получателей, которых осталось сохранить
+	MovingsBuilt(movings []models.InterfirmMovingDoc)
+	// MovingSaved — перемещение получателю сохранено
+	MovingSaved(moving models.InterfirmMovingDoc)
+	// MovingFailed — перемещение получателю сохранить не удалось
+	MovingFailed(moving models.InterfirmMovingDoc, err error)
+}
+
+// noopObserver — наблюдатель по умолчанию, ничего не делает
+type noopObserver struct{}
+
+func (noopObserver) Stage(string)                                  {}
+func (noopObserver) MovingsBuilt([]models.InterfirmMovingDoc)      {}
+func (noopObserver) MovingSaved(models.InterfirmMovingDoc)         {}
+func (noopObserver) MovingFailed(models.InterfirmMovingDoc, error) {}
+
+// transientRetryDelays — паузы перед повторами при взаимоблокировках и таймаутах SQL Server
+var transientRetryDelays = []time.Duration{500 * time.Millisecond, 2 * time.Second, 5 * time.Second}
+
+// ProcessOffer создаёт межфирменные перемещения по заявке от имени пользователя user.
+// Каждый получатель сохраняется своей транзакцией, итог по нему пишется в OFFER_RECEIVER_OUTCOME.
+// Если часть получателей не сохранилась, сохранённые остаются, а заявка переводится в статус "ошибка"
+// с причиной в истории; RetryOffer повторит только неудачных. Когда сохранены все — статус "отработана".
+// Повторный вызов для отработанной заявки не создаёт документов и возвращает ранее созданные.
+// progress получает ход обработки; nil — не нужен.
+func (s *OfferService) ProcessOffer(ctx context.Context, offerID int64, user models.User, comment string, progress ProcessObserver) (*models.OfferProcessResult, error) {
+	return s.process(ctx, offerID, user, comment, false, progress)
+}
+
+// RetryOffer повторяет обработку заявки в статусе "ошибка" только для получателей,
+// перемещения которым ещё не сохранены
+func (s *OfferService) RetryOffer(ctx context.Context, offerID int64, user models.User, comment string, progress ProcessObserver) (*models.OfferProcessResult, error) {
+	return s.process(ctx, offerID, user, comment, true, progress)
+}
+
+func (s *OfferService) process(ctx context.Context, offerID int64, user models.User, comment string, retry bool, progress ProcessObserver) (*models.OfferProcessResult, error) {
+	if progress == nil {
+		progress = noopObserver{}
+	}
+
+	// 0. Проверяем, что заявку можно отработать
+	offer, err := s.repo.GetOffer(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	if offer.Status == models.OfferStatusProcessed {
+		log.Printf("[Offer %d] Already processed, returning existing movings", offerID)
+		return s.processedResult(ctx, offerID, true)
+	}
+	if retry && offer.Status != models.OfferStatusError {
+		return nil, &models.OfferStateError{OfferID: offerID, Status: offer.Status, Action: "retry"}
+	}
+	if !models.CanTransitionOffer(offer.Status, models.OfferStatusProcessed) {
+		return nil, &models.OfferStateError{OfferID: offerID, Status: offer.Status, Action: "process"}
+	}
+
+	// 1. Проверяем склады и партии до вызова процедуры: иначе нехватка склада всплывает ошибкой сканирования.
+	// Получатели, которым перемещение уже сохранено, не проверяются.
+	progress.Stage(models.JobStageValidate)
+	problems, err := s.repo.ValidateOfferItems(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		log.Printf("[Offer %d] Validation failed: %d items cannot be processed", offerID, len(problems))
+		return nil, &models.OfferValidationError{OfferID: offerID, Problems: problems}
+	}
+
+	// 2-3. Готовим XML перемещений без записи в базу, кроме уже сохранённых получателей
+	progress.Stage(models.JobStageGenerate)
+	saved, err := s.repo.GetSavedReceivers(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	var movings []models.InterfirmMovingDoc
+	err = withTransientRetry(ctx, fmt.Sprintf("offer %d generation", offerID), func() error {
+		var err error
+		movings, err = s.buildMovings(ctx, offerID, user, saved)
+		return err
+	})
+	if err != nil {
+		s.markFailed(ctx, offerID, user.Name, err)
+		return nil, err
+	}
+	progress.MovingsBuilt(movings)
+
+	// Сохраняем XML до проведения: если ERP отклонит документ, его можно будет скачать и загрузить вручную
+	if err := s.repo.SaveOfferDocuments(ctx, offerID, user.Name, movings); err != nil {
+		log.Printf("[ERROR][Offer %d] Failed to store generated XML: %v", offerID, err)
+	}
+
+	// 4. Сохраняем перемещения по одному получателю
+	log.Printf("[Offer %d] Saving %d interfirm movings via USP_INTERFIRM_MOVING_SAVE (%d saved earlier)...",
+		offerID, len(movings), len(saved))
+	progress.Stage(models.JobStageSave)
+	procErr := &models.OfferProcessingError{OfferID: offerID, Saved: len(saved)}
+	for _, m := range movings {
+		err := withTransientRetry(ctx, fmt.Sprintf("offer %d moving for %s", offerID, m.ContractorTo), func() error {
+			return s.repo.SaveReceiverMoving(ctx, offerID, m, user.Name)
+		})
+		if err == nil || errors.Is(err, repositories.ErrReceiverAlreadySaved) {
+			procErr.Saved++
+			progress.MovingSaved(m)
+			continue
+		}
+
+		var stateErr *models.OfferStateError
+		if errors.As(err, &stateErr) {
+			// Параллельный запрос успел отработать заявку — отдаём его результат
+			if stateErr.Status == models.OfferStatusProcessed {
+				return s.processedResult(ctx, offerID, true)
+			}
+			// Заявку удалил кто-то другой — её статус не трогаем
+			return nil, err
+		}
+
+		log.Printf("[ERROR][Offer %d] Moving for %s failed: %v", offerID, m.ContractorTo, err)
+		progress.MovingFailed(m, err)
+		procErr.Failed = append(procErr.Failed, models.ReceiverOutcome{ContractorTo: m.ContractorTo, Error: err.Error()})
+		if recErr := s.repo.RecordReceiverFailure(context.WithoutCancel(ctx), offerID, m, err.Error(), user.Name); recErr != nil {
+			log.Printf("[ERROR][Offer %d] Failed to record outcome for %s: %v", offerID, m.ContractorTo, recErr)
+		}
+	}
+
+	if len(procErr.Failed) > 0 {
+		s.markFailed(ctx, offerID, user.Name, procErr)
+		return nil, procErr
+	}
+
+	// 5. Все получатели сохранены — заявка отработана
+	err = s.repo.ChangeOfferStatus(ctx, &models.OfferStatusChange{
+		OfferID: offerID,
+		From:    offer.Status,
+		To:      models.OfferStatusProcessed,
+		User:    user.Name,
+		Comment: comment,
+	})
+	if err != nil {
+		var stateErr *models.OfferStateError
+		if errors.As(err, &stateErr) && stateErr.Status == models.OfferStatusProcessed {
+			return s.processedResult(ctx, offerID, true)
+		}
+		return nil, err
+	}
+
+	log.Printf("[SUCCESS][Offer %d] Offer processed and marked as 'processed'", offerID)
+	return s.processedResult(ctx, offerID, false)
+}
+
+// withTransientRetry выполняет op и повторяет её с паузами transientRetryDelays,
+// пока ошибка временная (взаимоблокировка, таймаут) и контекст не отменён
+func withTransientRetry(ctx context.Context, what string, op func() error) error {
+	err := op()
+	for _, delay := range transientRetryDelays {
+		if err == nil || !repositories.IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("[RETRY] %s: transient error, retrying in %s: %v", what, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		err = op()
+	}
+	return err
+}
+
+// ValidateOffer возвращает позиции заявки, которые не пройдут обработку, с причинами
+func (s *OfferService) ValidateOffer(ctx context.Context, offerID int64) ([]models.OfferItemProblem, error) {
+	if _, err := s.repo.GetOffer(ctx, offerID); err != nil {
+		return nil, err
+	}
+	return s.repo.ValidateOfferItems(ctx, offerID)
+}
+
+func (s *OfferService) GetReceiverOutcomes(ctx context.Context, offerID int64) ([]models.ReceiverOutcome, error) {
+	return s.repo.GetReceiverOutcomes(ctx, offerID)
+}
+
+func (s *OfferService) GetOfferMovings(ctx context.Context, offerID int64) ([]models.OfferMoving, error) {
+	return s.repo.GetOfferMovings(ctx, offerID)
+}
+
+// GetOfferDocuments возвращает все сформированные документы заявки без XML
+func (s *OfferService) GetOfferDocuments(ctx context.Context, offerID int64) ([]models.OfferDocument, error) {
+	return s.repo.GetOfferDocuments(ctx, offerID, false)
+}
+
+// GetLatestOfferDocuments возвращает последний документ по каждому получателю вместе с XML
+func (s *OfferService) GetLatestOfferDocuments(ctx context.Context, offerID int64) ([]models.OfferDocument, error) {
+	return s.repo.GetOfferDocuments(ctx, offerID, true)
+}
+
+func (s *OfferService) GetOfferDocument(ctx context.Context, offerID, documentID int64) (*models.OfferDocument, error) {
+	return s.repo.GetOfferDocument(ctx, offerID, documentID)
+}
+
+// processedResult собирает результат обработки по сохранённым связям заявки с перемещениями
+func (s *OfferService) processedResult(ctx context.Context, offerID int64, already bool) (*models.OfferProcessResult, error) {
+	movings, err := s.repo.GetOfferMovings(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.OfferProcessResult{
+		OfferID:          offerID,
+		Status:           models.OfferStatusName(models.OfferStatusProcessed),
+		AlreadyProcessed: already,
+		Movings:          movings,
+	}, nil
 }
 
-func (s *OfferService) DeleteOffer(ctx context.Context, offerID int64) error {
-	return s.repo.UpdateOfferStatus(ctx, offerID, models.OfferStatusDeleted)
+// markFailed переводит заявку в статус "ошибка" с причиной cause
+func (s *OfferService) markFailed(ctx context.Context, offerID int64, userName string, cause error) {
+	err := s.transition(context.WithoutCancel(ctx), &models.OfferStatusChange{
+		OfferID: offerID,
+		To:      models.OfferStatusError,
+		User:    userName,
+		Error:   cause.Error(),
+	}, "mark as failed")
+	if err != nil {
+		log.Printf("[ERROR][Offer %d] Failed to mark offer as failed: %v", offerID, err)
+	}
 }
 
-func (s *OfferService) ProcessOffer(ctx context.Context, offerID int64) error {
+// buildMovings генерирует XML межфирменных перемещений по заявке, по одному на получателя,
+// пропуская получателей из skip (перемещение уже сохранено).
+// Если хотя бы одну строку нельзя провести, возвращает ошибку с описанием проблем.
+func (s *OfferService) buildMovings(ctx context.Context, offerID int64, user models.User, skip map[string]bool) ([]models.InterfirmMovingDoc, error) {
 	// 1. Получаем данные из процедуры
 	rows, err := s.repo.ProcessOffer(ctx, offerID)
 	if err != nil {
-		return fmt.Errorf("failed to generate interfirm data: %w", err)
+		return nil, fmt.Errorf("failed to generate interfirm data: %w", err)
 	}
 
 	if len(rows) == 0 {
-		return fmt.Errorf("no items found in offer %d", offerID)
+		return nil, fmt.Errorf("no items found in offer %d", offerID)
 	}
+	rows = withoutReceivers(rows, skip)
 
 	// 2. Группируем по ID_CONTRACTOR_GLOBAL_TO
-	grouped := make(map[string][]models.InterfirmRow)
-	for _, row := range rows {
-		key := row.IDContractorGlobalTo
-		grouped[key] = append(grouped[key], row)
+	receivers, grouped := groupInterfirmRows(rows)
+	if problems := rowProblems(rows); len(problems) > 0 {
+		p := problems[0]
+		return nil, fmt.Errorf("offer %d has %d rows that cannot be processed, e.g. lot %d for %s: %s",
+			offerID, len(problems), p.IDLotFrom, p.ContractorTo, strings.Join(p.Problems, "; "))
 	}
 
-	// 3. Для каждой группы генерируем XML и сохраняем
-	for contractorID, items := range grouped {
-		log.Printf("[Offer %d] Building interfirm moving for contractor: %s, item count: %d",
-			offerID, contractorID, len(items))
-
-		// Генерируем XML
-		xmlDoc, err := s.buildInterfirmXML(items)
+	// 3. Для каждой группы генерируем XML
+	movings := make([]models.InterfirmMovingDoc, 0, len(receivers))
+	for _, contractorID := range receivers {
+		doc, err := s.buildMovingDoc(offerID, contractorID, grouped[contractorID], user)
 		if err != nil {
-			return fmt.Errorf("failed to build XML for contractor %s: %w", contractorID, err)
+			return nil, err
 		}
+		movings = append(movings, *doc)
+	}
 
-		// Сериализуем в строку
-		xmlData, err := xml.Marshal(xmlDoc)
-		if err != nil {
-			return fmt.Errorf("failed to marshal XML for %s: %w", contractorID, err)
-		}
+	return movings, nil
+}
+
+// PreviewOffer выполняет обработку заявки вхолостую: вызывает usp_GenerateInterfirmMovingFromOffer
+// и строит XML, но не вызывает USP_INTERFIRM_MOVING_SAVE. Возвращает группировку по получателям,
+// склады, количества, суммы, XML и строки, которые не удастся провести.
+// Если позиции не прошли проверку складов и партий, процедура не вызывается.
+func (s *OfferService) PreviewOffer(ctx context.Context, offerID int64, user models.User) (*models.OfferPreview, error) {
+	offer, err := s.repo.GetOffer(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	problems, err := s.repo.ValidateOfferItems(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return &models.OfferPreview{
+			OfferID:      offerID,
+			Status:       offer.Status,
+			ItemProblems: problems,
+			Receivers:    []models.MovingPreview{},
+			FailedRows:   []models.InterfirmRowProblem{},
+		}, nil
+	}
+
+	rows, err := s.repo.ProcessOffer(ctx, offerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate interfirm data: %w", err)
+	}
 
-		fullXML := string(xmlData)
+	// После частичной обработки показываем только получателей, которым перемещение ещё не сохранено
+	saved, err := s.repo.GetSavedReceivers(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	rows = withoutReceivers(rows, saved)
 
-		// 🔹 ЛОГ: выводим XML (в одну строку, без переносов)
-		log.Printf("[Offer %d][Contractor %s] Generated XML:\n%s",
-			offerID, contractorID, fullXML)
+	receivers, grouped := groupInterfirmRows(rows)
+	preview := &models.OfferPreview{
+		OfferID:      offerID,
+		Status:       offer.Status,
+		ItemProblems: []models.OfferItemProblem{},
+		Receivers:    make([]models.MovingPreview, 0, len(receivers)),
+		FailedRows:   rowProblems(rows),
+	}
+	preview.CanProcess = len(rows) > 0 && len(preview.FailedRows) == 0 &&
+		models.CanTransitionOffer(offer.Status, models.OfferStatusProcessed)
 
-		// 🔹 ЛОГ: начало сохранения
-		log.Printf("[Offer %d][Contractor %s] Sending XML to USP_INTERFIRM_MOVING_SAVE...", offerID, contractorID)
+	for _, contractorID := range receivers {
+		items := grouped[contractorID]
+		first := items[0]
+		mp := models.MovingPreview{
+			ContractorTo:     contractorID,
+			MovingGlobal:     first.IDInterfirmMovingGlobal,
+			StoreFromMain:    first.IDStoreFromMain,
+			StoreFromTransit: first.IDStoreFromTransit,
+			StoreToMain:      first.IDStoreToMain,
+			StoreToTransit:   first.IDStoreToTransit,
+			ItemCount:        len(items),
+			Items:            make([]models.MovingItemPreview, 0, len(items)),
+			Valid:            true,
+		}
+		for _, r := range items {
+			mp.Quantity += r.Quantity
+			mp.SumSupplier += r.SumSupplier
+			mp.SVatSupplier += r.SVatSupplier
+			mp.SumRetail += r.Quantity * r.PVatRetail
+			mp.SVatRetail += r.Quantity * r.VatRetail
+			mp.Items = append(mp.Items, models.MovingItemPreview{
+				IDLotFrom:    r.IDLotFrom,
+				Quantity:     r.Quantity,
+				SumSupplier:  r.SumSupplier,
+				SVatSupplier: r.SVatSupplier,
+				PriceRetail:  r.PVatRetail,
+				VatRetail:    r.VatRetail,
+				IsKiz:        r.IsKiz == 1,
+			})
+			if len(r.Problems) > 0 {
+				mp.Valid = false
+			}
+		}
 
-		// Сохраняем через хранимку
-		if err := s.repo.SaveInterfirmMoving(ctx, fullXML); err != nil {
-			log.Printf("[ERROR][Offer %d][Contractor %s] Failed to save interfirm moving: %v",
-				offerID, contractorID, err)
-			return fmt.Errorf("failed to save interfirm moving for %s: %w", contractorID, err)
+		if mp.Valid {
+			doc, err := s.buildMovingDoc(offerID, contractorID, items, user)
+			if err != nil {
+				return nil, err
+			}
+			mp.XML = doc.XML
 		}
 
-		// 🔹 ЛОГ: успех
-		log.Printf("[SUCCESS][Offer %d][Contractor %s] Interfirm moving saved successfully",
-			offerID, contractorID)
+		preview.Receivers = append(preview.Receivers, mp)
+	}
+
+	return preview, nil
+}
+
+// buildMovingDoc строит XML перемещения одному получателю от имени пользователя user
+func (s *OfferService) buildMovingDoc(offerID int64, contractorID string, items []models.InterfirmRow, user models.User) (*models.InterfirmMovingDoc, error) {
+	log.Printf("[Offer %d] Building interfirm moving for contractor: %s, item count: %d",
+		offerID, contractorID, len(items))
+
+	// Генерируем XML
+	xmlDoc, err := s.buildInterfirmXML(items)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build XML for contractor %s: %w", contractorID, err)
 	}
+	xmlDoc.Moving.IDUser = int64(user.UserNum)
 
-	// 4. Меняем статус заявки на "отработана"
-	if err := s.repo.UpdateOfferStatus(ctx, offerID, models.OfferStatusProcessed); err != nil {
-		log.Printf("[ERROR][Offer %d] Failed to update offer status: %v", offerID, err)
-		return fmt.Errorf("failed to update offer status to processed: %w", err)
+	// Сериализуем в строку
+	xmlData, err := xml.Marshal(xmlDoc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal XML for %s: %w", contractorID, err)
 	}
 
-	log.Printf("[SUCCESS][Offer %d] Offer processed and marked as 'processed'", offerID)
-	return nil
+	fullXML := string(xmlData)
+
+	// 🔹 ЛОГ: выводим XML (в одну строку, без переносов)
+	log.Printf("[Offer %d][Contractor %s] Generated XML:\n%s",
+		offerID, contractorID, fullXML)
+
+	return &models.InterfirmMovingDoc{
+		ContractorTo: contractorID,
+		MovingGlobal: items[0].IDInterfirmMovingGlobal,
+		ItemCount:    len(items),
+		XML:          fullXML,
+	}, nil
+}
+
+// groupInterfirmRows группирует строки по получателю в порядке первого появления.
+// Перемещение берёт склад MAIN отправителя из первой строки, поэтому строки
+// с партиями на другом складе помечаются как проблемные.
+func groupInterfirmRows(rows []models.InterfirmRow) ([]string, map[string][]models.InterfirmRow) {
+	grouped := make(map[string][]models.InterfirmRow)
+	var receivers []string
+	for i := range rows {
+		row := &rows[i]
+		key := row.IDContractorGlobalTo
+		if first, ok := grouped[key]; ok {
+			main := first[0].IDStoreFromMain
+			if row.IDStoreFromMain != 0 && main != 0 && row.IDStoreFromMain != main {
+				row.Problems = append(row.Problems,
+					fmt.Sprintf("lot is on store %d, moving is from MAIN store %d", row.IDStoreFromMain, main))
+			}
+		} else {
+			receivers = append(receivers, key)
+		}
+		grouped[key] = append(grouped[key], *row)
+	}
+	return receivers, grouped
+}
+
+// withoutReceivers убирает строки получателей из skip (GUID в верхнем регистре)
+func withoutReceivers(rows []models.InterfirmRow, skip map[string]bool) []models.InterfirmRow {
+	if len(skip) == 0 {
+		return rows
+	}
+	kept := make([]models.InterfirmRow, 0, len(rows))
+	for _, r := range rows {
+		if !skip[strings.ToUpper(r.IDContractorGlobalTo)] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// rowProblems собирает строки, которые нельзя провести
+func rowProblems(rows []models.InterfirmRow) []models.InterfirmRowProblem {
+	problems := []models.InterfirmRowProblem{}
+	for _, r := range rows {
+		if len(r.Problems) == 0 {
+			continue
+		}
+		problems = append(problems, models.InterfirmRowProblem{
+			ContractorTo: r.IDContractorGlobalTo,
+			IDLotFrom:    r.IDLotFrom,
+			Quantity:     r.Quantity,
+			Problems:     r.Problems,
+		})
+	}
+	return problems
 }
 
 func (s *OfferService) buildInterfirmXML(rows []models.InterfirmRow) (*models.InterfirmXML, error) {
@@ -1,18 +1,80 @@
 package services
 
 import (
+	"context"
+
 	"RemainsManager/internal/models"
 	"RemainsManager/internal/repositories"
 )
 
 type UserService struct {
-	repo *repositories.UserRepository
+	repo     *repositories.UserRepository
+	access   *AccessService
+	pharmacy *PharmacyService
 }
 
-func NewUserService(repo *repositories.UserRepository) *UserService {
-	return &UserService{repo: repo}
+func NewUserService(repo *repositories.UserRepository, access *AccessService, pharmacy *PharmacyService) *UserService {
+	return &UserService{repo: repo, access: access, pharmacy: pharmacy}
 }
 
 func (s *UserService) GetAllUsers() ([]models.User, error) {
 	return s.repo.GetAllUsers()
 }
+
+// GetMe собирает профиль пользователя: запись meta_user, роли, аптеки и настройки.
+// Для API-ключей записи meta_user нет — возвращается имя из токена.
+func (s *UserService) GetMe(ctx context.Context, claims *models.UserClaims) (*models.Me, error) {
+	user, err := s.repo.GetUserByName(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		u := claims.User()
+		user = &u
+	}
+
+	scope, err := s.access.Scope(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	pharmacies := []models.Pharmacy{}
+	if len(scope.ContractorIDs) > 0 {
+		all, err := s.pharmacy.GetPharmacies()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range all {
+			if scope.Allows(p.ID_CONTRACTOR_GLOBAL) {
+				pharmacies = append(pharmacies, p)
+			}
+		}
+	}
+
+	prefs, err := s.repo.GetPreferences(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := claims.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	permissions := claims.Permissions()
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	return &models.Me{
+		User:          *user,
+		Roles:         roles,
+		Permissions:   permissions,
+		AllPharmacies: scope.All,
+		Pharmacies:    pharmacies,
+		Preferences:   *prefs,
+	}, nil
+}
+
+func (s *UserService) SavePreferences(ctx context.Context, username string, prefs models.UserPreferences) error {
+	return s.repo.SavePreferences(ctx, username, prefs)
+}
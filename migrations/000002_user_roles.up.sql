@@ -0,0 +1,13 @@
+-- 000002_user_roles.up.sql
+-- Роли пользователей (viewer, pharmacist, logistician, admin).
+-- Пользователь без записей получает роль viewer.
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name = 'USER_ROLE' AND xtype = 'U')
+BEGIN
+CREATE TABLE USER_ROLE (
+                           USER_NAME NVARCHAR(50) NOT NULL,
+                           ROLE NVARCHAR(20) NOT NULL,
+
+                           CONSTRAINT PK_USER_ROLE PRIMARY KEY (USER_NAME, ROLE),
+                           CONSTRAINT CK_USER_ROLE_ROLE CHECK (ROLE IN ('viewer', 'pharmacist', 'logistician', 'admin'))
+);
+END
@@ -0,0 +1,12 @@
+-- 000003_user_by_username.up.sql
+-- GetUserByUsername возвращает вместе с хешем пароля данные пользователя,
+-- чтобы токен содержал имя и user_num.
+IF OBJECT_ID('GetUserByUsername', 'P') IS NOT NULL
+    DROP PROCEDURE GetUserByUsername;
+EXEC sp_executesql N'
+CREATE PROCEDURE GetUserByUsername
+    @Username NVARCHAR(50)
+AS
+BEGIN
+    SELECT PASSWORD_HASH, name, full_name, user_num FROM meta_user WHERE NAME = @Username
+END'
@@ -0,0 +1,11 @@
+-- 000004_revoked_tokens.up.sql
+-- Отозванные токены (logout, ротация refresh-токенов).
+-- Записи с истёкшим EXPIRES_AT больше не нужны и удаляются при следующем отзыве.
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name = 'REVOKED_TOKEN' AND xtype = 'U')
+BEGIN
+CREATE TABLE REVOKED_TOKEN (
+                               JTI NVARCHAR(64) NOT NULL PRIMARY KEY,
+                               EXPIRES_AT DATETIME2 NOT NULL,
+                               REVOKED_AT DATETIME2 NOT NULL DEFAULT GETDATE()
+);
+END
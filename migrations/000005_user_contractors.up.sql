@@ -0,0 +1,12 @@
+-- 000005_user_contractors.up.sql
+-- Аптеки, закреплённые за пользователем.
+-- Пользователи с разрешением pharmacies:all (логисты, администраторы) видят всю сеть.
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name = 'USER_CONTRACTOR' AND xtype = 'U')
+BEGIN
+CREATE TABLE USER_CONTRACTOR (
+                                 USER_NAME NVARCHAR(50) NOT NULL,
+                                 ID_CONTRACTOR_GLOBAL UNIQUEIDENTIFIER NOT NULL,
+
+                                 CONSTRAINT PK_USER_CONTRACTOR PRIMARY KEY (USER_NAME, ID_CONTRACTOR_GLOBAL)
+);
+END
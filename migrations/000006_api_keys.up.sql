@@ -0,0 +1,17 @@
+-- 000006_api_keys.up.sql
+-- API-ключи сервисных учётных записей (ночные задания, BI).
+-- Хранится только SHA-256 ключа; SCOPES — разрешения через запятую.
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name = 'API_KEY' AND xtype = 'U')
+BEGIN
+CREATE TABLE API_KEY (
+                         ID_API_KEY BIGINT IDENTITY(1,1) PRIMARY KEY,
+                         NAME NVARCHAR(100) NOT NULL UNIQUE,
+                         KEY_PREFIX NVARCHAR(16) NOT NULL,
+                         KEY_HASH CHAR(64) NOT NULL UNIQUE,
+                         SCOPES NVARCHAR(1000) NOT NULL,
+                         CREATED_BY NVARCHAR(50) NOT NULL,
+                         CREATED_AT DATETIME2 NOT NULL DEFAULT GETDATE(),
+                         LAST_USED_AT DATETIME2 NULL,
+                         REVOKED_AT DATETIME2 NULL
+);
+END
@@ -0,0 +1,12 @@
+-- 000007_user_preferences.up.sql
+-- Пользовательские настройки интерфейса; NULL — значение по умолчанию.
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name = 'USER_PREFERENCE' AND xtype = 'U')
+BEGIN
+CREATE TABLE USER_PREFERENCE (
+                                 USER_NAME NVARCHAR(50) NOT NULL PRIMARY KEY,
+                                 DEFAULT_DAYS INT NULL,
+                                 DEFAULT_CONTRACTOR UNIQUEIDENTIFIER NULL,
+                                 PAGE_SIZE INT NULL,
+                                 UPDATED_AT DATETIME2 NOT NULL DEFAULT GETDATE()
+);
+END
@@ -0,0 +1,21 @@
+-- 000008_audit_log.up.sql
+-- Журнал аудита: входы, неудачные входы и изменяющие вызовы /api.
+-- OUTCOME: success | denied | failure.
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name = 'AUDIT_LOG' AND xtype = 'U')
+BEGIN
+CREATE TABLE AUDIT_LOG (
+                           ID_AUDIT_LOG BIGINT IDENTITY(1,1) PRIMARY KEY,
+                           CREATED_AT DATETIME2 NOT NULL DEFAULT GETDATE(),
+                           USER_NAME NVARCHAR(100) NOT NULL,
+                           IP NVARCHAR(64) NOT NULL,
+                           ACTION NVARCHAR(30) NOT NULL,
+                           METHOD NVARCHAR(10) NULL,
+                           ROUTE NVARCHAR(200) NULL,
+                           ENTITY_ID NVARCHAR(100) NULL,
+                           STATUS INT NULL,
+                           OUTCOME NVARCHAR(20) NOT NULL,
+                           DETAILS NVARCHAR(500) NULL
+);
+CREATE INDEX IX_AUDIT_LOG_CREATED_AT ON AUDIT_LOG (CREATED_AT);
+CREATE INDEX IX_AUDIT_LOG_USER_NAME ON AUDIT_LOG (USER_NAME, CREATED_AT);
+END
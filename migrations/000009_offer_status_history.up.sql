@@ -0,0 +1,19 @@
+-- 000009_offer_status_history.up.sql
+-- История смены статусов заявки: кто, когда и почему.
+-- ERROR_MESSAGE заполняется при переходе в статус "ошибка" (3).
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name = 'OFFER_STATUS_HISTORY' AND xtype = 'U')
+BEGIN
+CREATE TABLE OFFER_STATUS_HISTORY (
+                                      ID_OFFER_STATUS_HISTORY BIGINT IDENTITY(1,1) PRIMARY KEY,
+                                      ID_OFFER BIGINT NOT NULL,
+                                      STATUS_FROM INT NOT NULL,
+                                      STATUS_TO INT NOT NULL,
+                                      USER_NAME NVARCHAR(100) NOT NULL,
+                                      CHANGED_AT DATETIME2 NOT NULL DEFAULT GETDATE(),
+                                      COMMENT NVARCHAR(500) NULL,
+                                      ERROR_MESSAGE NVARCHAR(2000) NULL,
+
+                                      CONSTRAINT FK_OFFER_STATUS_HISTORY_OFFER FOREIGN KEY (ID_OFFER) REFERENCES OFFER(ID_OFFER)
+);
+CREATE INDEX IX_OFFER_STATUS_HISTORY_OFFER ON OFFER_STATUS_HISTORY (ID_OFFER, CHANGED_AT);
+END
@@ -0,0 +1,19 @@
+-- 000010_offer_movings.up.sql
+-- Межфирменные перемещения, созданные при обработке заявки: одно на получателя.
+-- Уникальность (ID_OFFER, ID_CONTRACTOR_GLOBAL_TO) не даёт создать документы повторно.
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name = 'OFFER_MOVING' AND xtype = 'U')
+BEGIN
+CREATE TABLE OFFER_MOVING (
+                              ID_OFFER_MOVING BIGINT IDENTITY(1,1) PRIMARY KEY,
+                              ID_OFFER BIGINT NOT NULL,
+                              ID_CONTRACTOR_GLOBAL_TO UNIQUEIDENTIFIER NOT NULL,
+                              ID_INTERFIRM_MOVING_GLOBAL UNIQUEIDENTIFIER NOT NULL,
+                              ITEM_COUNT INT NOT NULL,
+                              CREATED_BY NVARCHAR(100) NOT NULL,
+                              CREATED_AT DATETIME2 NOT NULL DEFAULT GETDATE(),
+
+                              CONSTRAINT FK_OFFER_MOVING_OFFER FOREIGN KEY (ID_OFFER) REFERENCES OFFER(ID_OFFER),
+                              CONSTRAINT UQ_OFFER_MOVING_RECEIVER UNIQUE (ID_OFFER, ID_CONTRACTOR_GLOBAL_TO),
+                              CONSTRAINT UQ_OFFER_MOVING_DOCUMENT UNIQUE (ID_INTERFIRM_MOVING_GLOBAL)
+);
+END
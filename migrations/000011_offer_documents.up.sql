@@ -0,0 +1,20 @@
+-- 000011_offer_documents.up.sql
+-- XML межфирменных перемещений, сформированный при каждой попытке обработки заявки.
+-- Пишется отдельно от транзакции сохранения, чтобы документ, отклонённый ERP, можно было скачать и загрузить вручную.
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name = 'OFFER_DOCUMENT' AND xtype = 'U')
+BEGIN
+CREATE TABLE OFFER_DOCUMENT (
+                                ID_OFFER_DOCUMENT BIGINT IDENTITY(1,1) PRIMARY KEY,
+                                ID_OFFER BIGINT NOT NULL,
+                                ID_CONTRACTOR_GLOBAL_TO UNIQUEIDENTIFIER NOT NULL,
+                                ID_INTERFIRM_MOVING_GLOBAL UNIQUEIDENTIFIER NOT NULL,
+                                ITEM_COUNT INT NOT NULL,
+                                XML_DATA NVARCHAR(MAX) NOT NULL,
+                                CREATED_BY NVARCHAR(100) NOT NULL,
+                                CREATED_AT DATETIME2 NOT NULL DEFAULT GETDATE(),
+
+                                CONSTRAINT FK_OFFER_DOCUMENT_OFFER FOREIGN KEY (ID_OFFER) REFERENCES OFFER(ID_OFFER)
+);
+
+CREATE INDEX IX_OFFER_DOCUMENT_OFFER ON OFFER_DOCUMENT (ID_OFFER, ID_CONTRACTOR_GLOBAL_TO, ID_OFFER_DOCUMENT);
+END
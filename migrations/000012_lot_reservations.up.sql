@@ -0,0 +1,138 @@
+-- 000012_lot_reservations.up.sql
+-- Резервы партий: количество, занятое позициями незавершённых заявок (новая, отправлена, ошибка).
+-- Удалённые заявки резерв снимают, отработанные уже списали остаток в ERP.
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'IX_OFFER_ITEM_LOT' AND object_id = OBJECT_ID('OFFER_ITEM'))
+    CREATE INDEX IX_OFFER_ITEM_LOT ON OFFER_ITEM (ID_LOT_GLOBAL) INCLUDE (ID_OFFER, QUANTITY);
+
+IF OBJECT_ID('V_LOT_RESERVATION', 'V') IS NOT NULL
+    DROP VIEW V_LOT_RESERVATION;
+
+EXEC sp_executesql N'CREATE VIEW V_LOT_RESERVATION
+AS
+SELECT
+    oi.ID_LOT_GLOBAL,
+    SUM(oi.QUANTITY) AS QUANTITY_RESERVED,
+    COUNT(DISTINCT oi.ID_OFFER) AS OFFER_COUNT
+FROM OFFER_ITEM oi
+INNER JOIN OFFER o ON o.ID_OFFER = oi.ID_OFFER
+WHERE o.STATUS IN (0, 1, 3)
+GROUP BY oi.ID_LOT_GLOBAL'
+
+-- GetInactiveStockProducts показывает партии, частично занятые заявками, с доступным остатком за вычетом резерва
+IF OBJECT_ID('GetInactiveStockProducts', 'P') IS NOT NULL
+    DROP PROCEDURE GetInactiveStockProducts;
+
+EXEC sp_executesql N'CREATE PROCEDURE GetInactiveStockProducts
+    @DAYS INT = 30,
+    @CONTRACTOR UNIQUEIDENTIFIER,
+    @PAGE INT = 1,
+    @LIMIT INT = 50,
+    @NAME NVARCHAR(255) = NULL
+AS
+BEGIN
+    SET NOCOUNT ON;
+
+    DECLARE @OFFSET INT = (@PAGE - 1) * @LIMIT;
+
+    -- Создаём временную таблицу для результатов
+    CREATE TABLE #Results (
+        ID_LOT_GLOBAL VARCHAR(36),
+        LOT_NAME VARCHAR(50),
+        NAME NVARCHAR(255),
+        QTY FLOAT,
+        PRICE_SAL MONEY,
+        PRICE_PROD MONEY,
+        DAYS_NO_MOVEMENT INT,
+        BEST_BEFORE DATE,
+        ID_GOODS_GLOBAL VARCHAR(36),
+        NO_MOVE BIT,
+        INTERNAL_BARCODE NVARCHAR(20),
+        QTY_RESERVED FLOAT,
+        QTY_AVAILABLE FLOAT
+    );
+
+    -- Вставка данных с пагинацией
+    INSERT INTO #Results
+    SELECT
+        L.ID_LOT_GLOBAL,
+        L.LOT_NAME,
+        G.NAME,
+        L.QUANTITY_REM AS QTY,  -- Убрано SUM, т.к. группировка по партии
+        L.PRICE_SAL,
+        L.PRICE_PROD,
+        ISNULL(DATEDIFF(DAY, lm_max.max_date, GETDATE()),0) AS DAYS_NO_MOVEMENT,
+        ISNULL(CAST(S.BEST_BEFORE AS DATE), CAST(GETDATE() AS DATE)) AS BEST_BEFORE,
+        G.ID_GOODS_GLOBAL,
+        CASE
+            WHEN NOT EXISTS (
+                SELECT 1
+                FROM LOT_MOVEMENT LMI
+                WHERE LMI.CODE_OP IN (''CHEQUE'', ''INVOICE_OUT'')
+                  AND LMI.ID_LOT_GLOBAL = L.ID_LOT_GLOBAL
+            ) THEN 1 ELSE 0
+        END AS NO_MOVE,
+        L.INTERNAL_BARCODE,
+        ISNULL(R.QUANTITY_RESERVED, 0) AS QTY_RESERVED,
+        L.QUANTITY_REM - ISNULL(R.QUANTITY_RESERVED, 0) AS QTY_AVAILABLE
+    FROM LOT L
+    INNER JOIN STORE ST ON ST.ID_STORE = L.ID_STORE
+    INNER JOIN CONTRACTOR C ON C.ID_CONTRACTOR = ST.ID_CONTRACTOR
+    INNER JOIN GOODS G ON G.ID_GOODS = L.ID_GOODS
+    LEFT JOIN SERIES S ON S.ID_SERIES = L.ID_SERIES
+    LEFT JOIN V_LOT_RESERVATION R ON R.ID_LOT_GLOBAL = L.ID_LOT_GLOBAL
+    LEFT JOIN (
+        SELECT
+            ID_LOT_GLOBAL,
+            MAX(DATE_OP) AS max_date
+        FROM LOT_MOVEMENT
+        WHERE CODE_OP IN (''CHEQUE'', ''INVOICE_OUT'')
+        GROUP BY ID_LOT_GLOBAL
+    ) lm_max ON lm_max.ID_LOT_GLOBAL = L.ID_LOT_GLOBAL
+    WHERE
+        L.QUANTITY_REM > 0
+        AND C.ID_CONTRACTOR_GLOBAL = @CONTRACTOR  -- ← раскомментировано!
+        AND L.INCOMING_DATE < DATEADD(DAY, -@DAYS, GETDATE())
+        AND (@NAME IS NULL OR (G.NAME LIKE ''%'' + @NAME + ''%'' OR L.INTERNAL_BARCODE like ''%'' + @NAME + ''%'' ))
+        AND NOT EXISTS (
+            SELECT 1
+            FROM LOT_MOVEMENT LM
+            WHERE LM.CODE_OP IN (''CHEQUE'', ''INVOICE_OUT'')
+              AND LM.DATE_OP >= DATEADD(DAY, -@DAYS, GETDATE())
+              AND LM.ID_LOT_GLOBAL = L.ID_LOT_GLOBAL
+        )
+        AND L.QUANTITY_REM - ISNULL(R.QUANTITY_RESERVED, 0) > 0
+    ORDER BY G.NAME, L.ID_LOT_GLOBAL
+    OFFSET @OFFSET ROWS
+    FETCH NEXT @LIMIT ROWS ONLY;
+
+    -- Подсчёт общего количества подходящих партий (для пагинации)
+    SELECT
+        CEILING(COUNT(distinct l.ID_LOT_GLOBAL) * 1.0 / @LIMIT) AS TotalPages
+    INTO #TotalCount
+    FROM LOT L
+    INNER JOIN STORE ST ON ST.ID_STORE = L.ID_STORE
+    INNER JOIN CONTRACTOR C ON C.ID_CONTRACTOR = ST.ID_CONTRACTOR
+    INNER JOIN GOODS G ON G.ID_GOODS = L.ID_GOODS
+    LEFT JOIN V_LOT_RESERVATION R ON R.ID_LOT_GLOBAL = L.ID_LOT_GLOBAL
+    WHERE
+        L.QUANTITY_REM > 0
+        AND C.ID_CONTRACTOR_GLOBAL = @CONTRACTOR
+        AND L.INCOMING_DATE < DATEADD(DAY, -@DAYS, GETDATE())
+        AND (@NAME IS NULL OR (G.NAME LIKE ''%'' + @NAME + ''%'' OR L.INTERNAL_BARCODE like ''%'' + @NAME + ''%'' ))
+        AND NOT EXISTS (
+            SELECT 1
+            FROM LOT_MOVEMENT LM
+            WHERE LM.CODE_OP IN (''CHEQUE'', ''INVOICE_OUT'')
+              AND LM.DATE_OP >= DATEADD(DAY, -@DAYS, GETDATE())
+              AND LM.ID_LOT_GLOBAL = L.ID_LOT_GLOBAL
+        )
+        AND L.QUANTITY_REM - ISNULL(R.QUANTITY_RESERVED, 0) > 0;
+
+    -- Возврат результатов
+    SELECT * FROM #Results;
+    SELECT * FROM #TotalCount;
+
+    -- Очистка (опционально, но хорошая практика)
+    DROP TABLE #Results;
+    DROP TABLE #TotalCount;
+    END'
@@ -0,0 +1,12 @@
+-- 000013_offer_drafts.up.sql
+-- У отправителя может быть несколько именованных черновиков заявок.
+-- IS_DEFAULT отмечает заявку "на сегодня", которую возвращает GET /api/offer;
+-- все заявки, созданные до этой миграции, были такими.
+IF NOT EXISTS (SELECT * FROM sys.columns WHERE name = 'IS_DEFAULT' AND object_id = OBJECT_ID('OFFER'))
+BEGIN
+    ALTER TABLE OFFER ADD IS_DEFAULT BIT NOT NULL CONSTRAINT DF_OFFER_IS_DEFAULT DEFAULT 0;
+    EXEC sp_executesql N'UPDATE OFFER SET IS_DEFAULT = 1';
+END
+
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'IX_OFFER_SENDER_STATUS' AND object_id = OBJECT_ID('OFFER'))
+    CREATE INDEX IX_OFFER_SENDER_STATUS ON OFFER (ID_CONTRACTOR_GLOBAL_FROM, STATUS) INCLUDE (NAME, CREATED_AT, IS_DEFAULT);
@@ -0,0 +1,22 @@
+-- 000014_offer_default_unique.up.sql
+-- Не больше одной открытой заявки "на сегодня" (IS_DEFAULT) у отправителя за день.
+-- OFFER_DATE — дата создания отдельным столбцом: фильтрованный индекс не допускает вычисляемых столбцов.
+IF NOT EXISTS (SELECT * FROM sys.columns WHERE name = 'OFFER_DATE' AND object_id = OBJECT_ID('OFFER'))
+BEGIN
+    ALTER TABLE OFFER ADD OFFER_DATE DATE NOT NULL CONSTRAINT DF_OFFER_OFFER_DATE DEFAULT CAST(GETDATE() AS DATE);
+    EXEC sp_executesql N'UPDATE OFFER SET OFFER_DATE = CAST(ISNULL(CREATED_AT, GETDATE()) AS DATE)';
+END
+
+-- Дубликаты, созданные параллельными запросами, остаются именованными черновиками; заявкой по умолчанию — последняя
+EXEC sp_executesql N'
+WITH ranked AS (
+    SELECT IS_DEFAULT,
+           ROW_NUMBER() OVER (PARTITION BY ID_CONTRACTOR_GLOBAL_FROM, OFFER_DATE ORDER BY ID_OFFER DESC) AS RN
+    FROM OFFER
+    WHERE IS_DEFAULT = 1 AND STATUS IN (0, 1, 3)
+)
+UPDATE ranked SET IS_DEFAULT = 0 WHERE RN > 1';
+
+IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name = 'UX_OFFER_DEFAULT_PER_DAY' AND object_id = OBJECT_ID('OFFER'))
+    EXEC sp_executesql N'CREATE UNIQUE INDEX UX_OFFER_DEFAULT_PER_DAY ON OFFER (ID_CONTRACTOR_GLOBAL_FROM, OFFER_DATE)
+        WHERE IS_DEFAULT = 1 AND STATUS IN (0, 1, 3)';
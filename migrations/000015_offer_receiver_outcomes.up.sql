@@ -0,0 +1,21 @@
+-- 000015_offer_receiver_outcomes.up.sql
+-- Итог обработки заявки по каждому получателю: последняя попытка, число попыток и ошибка.
+-- Повторная обработка берёт только получателей без сохранённого перемещения (OFFER_MOVING).
+IF NOT EXISTS (SELECT * FROM sysobjects WHERE name = 'OFFER_RECEIVER_OUTCOME' AND xtype = 'U')
+BEGIN
+CREATE TABLE OFFER_RECEIVER_OUTCOME (
+                                        ID_OFFER_RECEIVER_OUTCOME BIGINT IDENTITY(1,1) PRIMARY KEY,
+                                        ID_OFFER BIGINT NOT NULL,
+                                        ID_CONTRACTOR_GLOBAL_TO UNIQUEIDENTIFIER NOT NULL,
+                                        STATUS NVARCHAR(20) NOT NULL,
+                                        ID_INTERFIRM_MOVING_GLOBAL UNIQUEIDENTIFIER NULL,
+                                        ITEM_COUNT INT NOT NULL,
+                                        ATTEMPTS INT NOT NULL DEFAULT 1,
+                                        ERROR_MESSAGE NVARCHAR(2000) NULL,
+                                        USER_NAME NVARCHAR(100) NOT NULL,
+                                        UPDATED_AT DATETIME2 NOT NULL DEFAULT GETDATE(),
+
+                                        CONSTRAINT FK_OFFER_RECEIVER_OUTCOME_OFFER FOREIGN KEY (ID_OFFER) REFERENCES OFFER(ID_OFFER),
+                                        CONSTRAINT UQ_OFFER_RECEIVER_OUTCOME UNIQUE (ID_OFFER, ID_CONTRACTOR_GLOBAL_TO)
+);
+END
@@ -0,0 +1,9 @@
+-- 000016_api_key_contractors.up.sql
+-- API-ключи закрепляются за аптеками так же, как пользователи: USER_CONTRACTOR с USER_NAME = 'apikey:<имя>'.
+-- Имя такой учётной записи длиннее 50 символов, поэтому столбец расширяется до 100, как USER_NAME в журналах.
+IF COL_LENGTH('USER_CONTRACTOR', 'USER_NAME') < 200
+BEGIN
+    ALTER TABLE USER_CONTRACTOR DROP CONSTRAINT PK_USER_CONTRACTOR;
+    ALTER TABLE USER_CONTRACTOR ALTER COLUMN USER_NAME NVARCHAR(100) NOT NULL;
+    ALTER TABLE USER_CONTRACTOR ADD CONSTRAINT PK_USER_CONTRACTOR PRIMARY KEY (USER_NAME, ID_CONTRACTOR_GLOBAL);
+END
@@ -0,0 +1,15 @@
+-- 000017_user_preference_name.up.sql
+-- Настройки пишут и API-ключи (USER_NAME = 'apikey:<имя>', до 100 символов), поэтому столбец
+-- расширяется до 100, как USER_NAME в остальных таблицах. Первичный ключ создан без имени — ищем его.
+IF COL_LENGTH('USER_PREFERENCE', 'USER_NAME') < 200
+BEGIN
+    DECLARE @pk SYSNAME = (
+        SELECT name FROM sys.key_constraints
+        WHERE parent_object_id = OBJECT_ID('USER_PREFERENCE') AND type = 'PK'
+    );
+    IF @pk IS NOT NULL
+        EXEC (N'ALTER TABLE USER_PREFERENCE DROP CONSTRAINT ' + QUOTENAME(@pk));
+
+    ALTER TABLE USER_PREFERENCE ALTER COLUMN USER_NAME NVARCHAR(100) NOT NULL;
+    ALTER TABLE USER_PREFERENCE ADD CONSTRAINT PK_USER_PREFERENCE PRIMARY KEY (USER_NAME);
+END
@@ -0,0 +1,20 @@
+-- 000018_reservation_saved_receivers.up.sql
+-- Позиции получателей, которым перемещение уже сохранено (OFFER_MOVING), списали остаток в ERP,
+-- даже если заявка осталась в статусе "ошибка". Резерв по ним снимается, иначе остаток учитывается дважды.
+IF OBJECT_ID('V_LOT_RESERVATION', 'V') IS NOT NULL
+    DROP VIEW V_LOT_RESERVATION;
+
+EXEC sp_executesql N'CREATE VIEW V_LOT_RESERVATION
+AS
+SELECT
+    oi.ID_LOT_GLOBAL,
+    SUM(oi.QUANTITY) AS QUANTITY_RESERVED,
+    COUNT(DISTINCT oi.ID_OFFER) AS OFFER_COUNT
+FROM OFFER_ITEM oi
+INNER JOIN OFFER o ON o.ID_OFFER = oi.ID_OFFER
+WHERE o.STATUS IN (0, 1, 3)
+  AND NOT EXISTS (
+      SELECT 1 FROM OFFER_MOVING om
+      WHERE om.ID_OFFER = oi.ID_OFFER AND om.ID_CONTRACTOR_GLOBAL_TO = oi.ID_CONTRACTOR_GLOBAL_TO
+  )
+GROUP BY oi.ID_LOT_GLOBAL'